@@ -0,0 +1,103 @@
+package equivalence
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Comparer tests whether a is equivalent to b. a will always be a value of the
+// type that the comparer was registered for, and b can be of any type.
+//
+// Values are passed in after being drilled down through pointers and
+// interfaces, so a comparer should be registered for a concrete type rather
+// than a pointer to it.
+type Comparer func(a, b reflect.Value) bool
+
+// Internal form of a comparer, which also records any difference found.
+type comparerFunc func(c *comparator, a, b reflect.Value) bool
+
+// Cache of reflect.Type -> comparerFunc
+var globalComparers sync.Map
+
+// Types that still have their built-in comparer for numbers, which compares
+// them the same way as any other number (see hasCustomComparer).
+// Cache of reflect.Type -> bool
+var builtInNumberComparers sync.Map
+
+func init() {
+	for _, t := range []reflect.Type{bigIntType, bigFloatType, bigRatType} {
+		registerComparerFunc(t, (*comparator).areBigNumbersEquivalent)
+		builtInNumberComparers.Store(t, true)
+	}
+	registerComparerFunc(timeType, (*comparator).areTimesEquivalent)
+}
+
+// RegisterComparer registers a comparer to use for all comparisons involving a
+// value of type t (on either side), in place of the normal rules. Passing a
+// nil comparer removes the comparer for that type.
+//
+// big.Int, big.Float, big.Rat, and time.Time are handled by built-in
+// comparers, which can also be replaced this way.
+//
+// To use a comparer for specific comparisons only, see WithComparer.
+func RegisterComparer(t reflect.Type, comparer Comparer) {
+	builtInNumberComparers.Delete(t)
+	if comparer == nil {
+		globalComparers.Delete(t)
+		return
+	}
+	registerComparerFunc(t, wrapComparer(t, comparer))
+}
+
+func registerComparerFunc(t reflect.Type, compare comparerFunc) {
+	globalComparers.Store(t, compare)
+}
+
+func wrapComparer(t reflect.Type, comparer Comparer) comparerFunc {
+	return func(c *comparator, a, b reflect.Value) bool {
+		var isEquivalent bool
+		if a.Type() == t {
+			isEquivalent = comparer(a, b)
+		} else {
+			isEquivalent = comparer(b, a)
+		}
+		if !isEquivalent {
+			return c.fail(ReasonValueDiffers, a, b)
+		}
+		return true
+	}
+}
+
+func (_this *comparator) lookupComparerForType(t reflect.Type) comparerFunc {
+	if compare, ok := _this.options.comparers[t]; ok {
+		return compare
+	}
+	if compare, ok := globalComparers.Load(t); ok {
+		return compare.(comparerFunc)
+	}
+	return nil
+}
+
+// Returns true if values of type t are compared using a comparer other than
+// the built-in ones for numbers.
+func (_this *comparator) hasCustomComparer(t reflect.Type) bool {
+	if _, ok := _this.options.comparers[t]; ok {
+		return true
+	}
+	if _, ok := builtInNumberComparers.Load(t); ok {
+		return false
+	}
+	return _this.lookupComparerForType(t) != nil
+}
+
+// Find the comparer to use for a and b (preferring a's type), or nil if
+// neither type has one.
+func (_this *comparator) lookupComparer(a, b reflect.Value) comparerFunc {
+	if compare := _this.lookupComparerForType(a.Type()); compare != nil {
+		return compare
+	}
+	if b.Type() != a.Type() {
+		return _this.lookupComparerForType(b.Type())
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package equivalence
+
+import (
+	"reflect"
+)
+
+func isComplex(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Complex64, reflect.Complex128:
+		return true
+	}
+	return false
+}
+
+// Compare complex numbers part by part, using the configured NaN and signed
+// zero rules.
+func (_this *comparator) areComplexesEquivalent(a, b reflect.Value) bool {
+	ac := a.Complex()
+	bc := b.Complex()
+	if !_this.areFloatsEquivalent(real(ac), real(bc)) || !_this.areFloatsEquivalent(imag(ac), imag(bc)) {
+		return _this.fail(ReasonValueDiffers, a, b)
+	}
+	return true
+}
+
+// Compare a complex number against a non-complex number (in either order).
+// They are equivalent if the complex number's imaginary part is zero, and its
+// real part is equivalent to the other number.
+func (_this *comparator) areComplexAndNumberEquivalent(a, b reflect.Value) bool {
+	complexIsA := isComplex(a)
+	c := a
+	if !complexIsA {
+		c = b
+	}
+	if !_this.areFloatsEquivalent(imag(c.Complex()), 0) {
+		return _this.fail(ReasonLossyConversion, a, b)
+	}
+
+	realPart := reflect.ValueOf(real(c.Complex()))
+	if complexIsA {
+		return _this.compareSubstitutes(a, b, realPart, b, _this.areNumbersEquivalent)
+	}
+	return _this.compareSubstitutes(a, b, a, realPart, _this.areNumbersEquivalent)
+}
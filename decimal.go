@@ -0,0 +1,91 @@
+package equivalence
+
+import (
+	"math/big"
+	"reflect"
+	"sync"
+)
+
+// RationalNumber can be implemented by numeric types (such as decimals) to be
+// compared numerically against ints, floats, big.Int, big.Float, big.Rat, and
+// other numeric types. Rat must return the exact value, and must not return
+// nil.
+type RationalNumber interface {
+	Rat() *big.Rat
+}
+
+// BigFloatNumber can be implemented by numeric types (such as decimals) to be
+// compared numerically against ints, floats, big.Int, big.Float, big.Rat, and
+// other numeric types. BigFloat must return the exact value, and must not
+// return nil.
+//
+// If a type implements both RationalNumber and BigFloatNumber, Rat is used.
+type BigFloatNumber interface {
+	BigFloat() *big.Float
+}
+
+var rationalNumberType = reflect.TypeOf((*RationalNumber)(nil)).Elem()
+var bigFloatNumberType = reflect.TypeOf((*BigFloatNumber)(nil)).Elem()
+
+// How the value of a decimal type can be read.
+type decimalMethods struct {
+	isRational bool
+	isBigFloat bool
+	// If true, the methods have a pointer receiver.
+	needsPointer bool
+}
+
+func (_this *decimalMethods) isDecimal() bool {
+	return _this.isRational || _this.isBigFloat
+}
+
+func newDecimalMethods(t reflect.Type) decimalMethods {
+	for _, receiverType := range []reflect.Type{t, reflect.PtrTo(t)} {
+		methods := decimalMethods{
+			isRational:   receiverType.Implements(rationalNumberType),
+			isBigFloat:   receiverType.Implements(bigFloatNumberType),
+			needsPointer: receiverType != t,
+		}
+		if methods.isDecimal() {
+			return methods
+		}
+	}
+	return decimalMethods{}
+}
+
+// Cache of reflect.Type -> decimalMethods
+var decimalMethodsCache sync.Map
+
+func getDecimalMethods(t reflect.Type) decimalMethods {
+	if methods, ok := decimalMethodsCache.Load(t); ok {
+		return methods.(decimalMethods)
+	}
+	methods := newDecimalMethods(t)
+	decimalMethodsCache.Store(t, methods)
+	return methods
+}
+
+// Returns true if v is of a type that implements RationalNumber or
+// BigFloatNumber.
+func isDecimal(v reflect.Value) bool {
+	t := v.Type()
+	// Only types declared in a package can have methods.
+	if t.PkgPath() == "" || !v.CanInterface() {
+		return false
+	}
+	methods := getDecimalMethods(t)
+	return methods.isDecimal()
+}
+
+// Get the value of a decimal. Exactly one of the results will be non-nil.
+func getDecimalValue(v reflect.Value) (r *big.Rat, f *big.Float) {
+	methods := getDecimalMethods(v.Type())
+	receiver := v
+	if methods.needsPointer {
+		receiver = getPointerTo(v)
+	}
+	if methods.isRational {
+		return receiver.Interface().(RationalNumber).Rat(), nil
+	}
+	return nil, receiver.Interface().(BigFloatNumber).BigFloat()
+}
@@ -0,0 +1,261 @@
+package equivalence
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	diffIndent      = "  "
+	diffMarkerEqual = " "
+	diffMarkerA     = "-"
+	diffMarkerB     = "+"
+	ansiColorA      = "\x1b[31m"
+	ansiColorB      = "\x1b[32m"
+	ansiColorNote   = "\x1b[2m"
+	ansiColorReset  = "\x1b[0m"
+)
+
+var emptyInterfaceType = reflect.TypeOf([]interface{}{}).Elem()
+
+// Diff renders the differences between a and b as a multi-line diff, with
+// lines from a marked by `-` and lines from b marked by `+`. Values are
+// formatted the same way as go-describe. Equal parts of the objects are
+// collapsed into a single line noting how many elements were left out.
+//
+// Returns an empty string if a and b are equivalent.
+func Diff(a, b interface{}) string {
+	return Compare(a, b).Diff(false)
+}
+
+// Diff renders this result as a multi-line diff (see the Diff function). If
+// useColor is true, the output is colored using ANSI escape codes.
+//
+// Returns an empty string if there are no differences.
+func (_this Result) Diff(useColor bool) string {
+	if _this.IsEquivalent() {
+		return ""
+	}
+	root := &diffNode{}
+	for _, difference := range _this.Differences {
+		root.add(difference, difference.elements)
+	}
+	renderer := diffRenderer{useColor: useColor}
+	root.render(&renderer, "", "")
+	return renderer.buff.String()
+}
+
+type diffRenderer struct {
+	buff     bytes.Buffer
+	useColor bool
+}
+
+func (_this *diffRenderer) writeLine(marker string, indent string, contents string) {
+	color := ""
+	switch {
+	case !_this.useColor:
+	case marker == diffMarkerA:
+		color = ansiColorA
+	case marker == diffMarkerB:
+		color = ansiColorB
+	case strings.HasPrefix(contents, "..."):
+		color = ansiColorNote
+	}
+	_this.buff.WriteString(color)
+	_this.buff.WriteString(marker)
+	_this.buff.WriteString(indent)
+	_this.buff.WriteString(contents)
+	if color != "" {
+		_this.buff.WriteString(ansiColorReset)
+	}
+	_this.buff.WriteString("\n")
+}
+
+// A diffNode is one location in the compared objects that either differs, or
+// contains something that differs.
+type diffNode struct {
+	// How this node is reached from its parent.
+	element     pathElement
+	differences []Difference
+	children    []*diffNode
+	childrenBy  map[string]*diffNode
+}
+
+func pathElementID(element pathElement) string {
+	switch element.elementType {
+	case pathElementMapKey:
+		return "k" + describeValue(element.key)
+	default:
+		return fmt.Sprintf("%v:%v", element.elementType, element.index)
+	}
+}
+
+func (_this *diffNode) add(difference Difference, remainingPath path) {
+	if len(remainingPath) == 0 {
+		_this.differences = append(_this.differences, difference)
+		return
+	}
+	element := remainingPath[0]
+	id := pathElementID(element)
+	child, ok := _this.childrenBy[id]
+	if !ok {
+		if _this.childrenBy == nil {
+			_this.childrenBy = make(map[string]*diffNode)
+		}
+		child = &diffNode{element: element}
+		_this.childrenBy[id] = child
+		_this.children = append(_this.children, child)
+	}
+	child.add(difference, remainingPath[1:])
+}
+
+func (_this *diffNode) label() string {
+	switch _this.element.elementType {
+	case pathElementField:
+		return _this.element.name + " = "
+	case pathElementMapKey:
+		return describeValue(_this.element.key) + " = "
+	default:
+		return fmt.Sprintf("[%v] = ", _this.element.index)
+	}
+}
+
+// Returns true if this node only exists on the b side of a map or unordered
+// array/slice.
+func (_this *diffNode) isMissingFromA() bool {
+	for _, difference := range _this.differences {
+		if difference.MissingFrom == SideA {
+			return true
+		}
+	}
+	return false
+}
+
+func (_this *diffNode) isUnmatchedElement() bool {
+	for _, difference := range _this.differences {
+		if difference.Reason == ReasonUnmatchedElement {
+			return true
+		}
+	}
+	return false
+}
+
+func describeTypeName(t reflect.Type) string {
+	if t == emptyInterfaceType {
+		return "interface"
+	}
+	return t.String()
+}
+
+func pluralize(count int, singular, plural string) string {
+	if count == 1 {
+		return fmt.Sprintf("%v %v", count, singular)
+	}
+	return fmt.Sprintf("%v %v", count, plural)
+}
+
+func (_this *diffNode) render(renderer *diffRenderer, indent string, label string) {
+	if len(_this.children) == 0 {
+		for _, difference := range _this.differences {
+			if difference.Note != "" {
+				renderer.writeLine(diffMarkerEqual, indent, "// "+difference.describeReason())
+			}
+			showTypes := difference.AType != nil && difference.BType != nil && difference.AType != difference.BType
+			renderValue := func(marker string, v reflect.Value, side Side) {
+				if difference.MissingFrom == side {
+					return
+				}
+				contents := label + describeValue(v)
+				if showTypes && v.IsValid() {
+					contents = fmt.Sprintf("%v (%v)", contents, v.Type())
+				}
+				renderer.writeLine(marker, indent, contents)
+			}
+			renderValue(diffMarkerA, difference.A, SideA)
+			renderValue(diffMarkerB, difference.B, SideB)
+		}
+		return
+	}
+
+	// All children share the same containers.
+	container := _this.children[0].element.containerA
+	if !container.IsValid() {
+		container = _this.children[0].element.containerB
+	}
+	aKind := _this.children[0].element.containerA.Kind()
+	bKind := _this.children[0].element.containerB.Kind()
+	isStructVsMap := (aKind == reflect.Struct && bKind == reflect.Map) ||
+		(aKind == reflect.Map && bKind == reflect.Struct)
+
+	var opener, closer, singular, plural string
+	var length int
+	switch container.Kind() {
+	case reflect.Struct:
+		opener = describeTypeName(container.Type()) + "<"
+		closer = ">"
+		singular, plural = "equal field", "equal fields"
+		length = container.NumField()
+		if isStructVsMap {
+			length = len(getMapFields(container.Type()))
+		}
+	case reflect.Map:
+		opener = describeTypeName(container.Type().Key()) + ":" + describeTypeName(container.Type().Elem()) + "{"
+		closer = "}"
+		singular, plural = "equal entry", "equal entries"
+		length = container.Len()
+	default:
+		opener = describeTypeName(container.Type().Elem()) + "["
+		closer = "]"
+		singular, plural = "equal element", "equal elements"
+		length = container.Len()
+	}
+
+	writeEqualNote := func(count int) {
+		if count > 0 {
+			renderer.writeLine(diffMarkerEqual, indent+diffIndent, "... "+pluralize(count, singular, plural))
+		}
+	}
+
+	renderer.writeLine(diffMarkerEqual, indent, label+opener)
+	for _, difference := range _this.differences {
+		if difference.Reason == ReasonLengthMismatch {
+			renderer.writeLine(diffMarkerEqual, indent+diffIndent, fmt.Sprintf("// %v: %v vs %v",
+				difference.Reason, difference.A.Len(), difference.B.Len()))
+		} else {
+			renderer.writeLine(diffMarkerEqual, indent+diffIndent, "// "+difference.describeReason())
+		}
+	}
+
+	isUnordered := false
+	for _, child := range _this.children {
+		if child.isUnmatchedElement() {
+			isUnordered = true
+			singular, plural = "matched element", "matched elements"
+			break
+		}
+	}
+
+	if container.Kind() == reflect.Map || isUnordered || isStructVsMap {
+		// Positions don't matter, so list the differences, then the number
+		// of elements that aren't listed.
+		unequalCount := 0
+		for _, child := range _this.children {
+			child.render(renderer, indent+diffIndent, child.label())
+			if !child.isMissingFromA() {
+				unequalCount++
+			}
+		}
+		writeEqualNote(length - unequalCount)
+	} else {
+		nextIndex := 0
+		for _, child := range _this.children {
+			writeEqualNote(child.element.index - nextIndex)
+			child.render(renderer, indent+diffIndent, child.label())
+			nextIndex = child.element.index + 1
+		}
+		writeEqualNote(length - nextIndex)
+	}
+	renderer.writeLine(diffMarkerEqual, indent, closer)
+}
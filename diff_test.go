@@ -0,0 +1,185 @@
+package equivalence
+
+import (
+	"strings"
+	"testing"
+)
+
+func assertDiff(t *testing.T, a, b interface{}, expected ...string) {
+	actual := Diff(a, b)
+	expectedDiff := strings.Join(expected, "\n")
+	if len(expected) > 0 {
+		expectedDiff += "\n"
+	}
+	if actual != expectedDiff {
+		t.Errorf("Expected diff:\n%v\nbut got:\n%v", expectedDiff, actual)
+	}
+}
+
+func TestDiffEquivalent(t *testing.T) {
+	assertDiff(t, 1, int8(1))
+}
+
+func TestDiffRoot(t *testing.T) {
+	assertDiff(t, 1, 2,
+		"-1",
+		"+2")
+	assertDiff(t, 1, "1",
+		"-1 (int)",
+		`+"1" (string)`)
+}
+
+func TestDiffCollapsesEqualElements(t *testing.T) {
+	a := make([]int, 10000)
+	b := make([]int, 10000)
+	b[5000] = 1
+	assertDiff(t, a, b,
+		" int[",
+		"   ... 5000 equal elements",
+		"-  [5000] = 0",
+		"+  [5000] = 1",
+		"   ... 4999 equal elements",
+		" ]")
+}
+
+func TestDiffLengthMismatch(t *testing.T) {
+	assertDiff(t, make([]int, 10000), make([]int, 10001),
+		" int[",
+		"   // length mismatch: 10000 vs 10001",
+		"   ... 10000 equal elements",
+		"+  [10000] = 0",
+		" ]")
+	assertDiff(t, []int{1, 2, 3, 4}, []int{1, 5},
+		" int[",
+		"   // length mismatch: 4 vs 2",
+		"   ... 1 equal element",
+		"-  [1] = 2",
+		"+  [1] = 5",
+		"-  [2] = 3",
+		"-  [3] = 4",
+		" ]")
+}
+
+func TestDiffNested(t *testing.T) {
+	a := ComplexStruct{
+		Map:     map[interface{}]interface{}{"x": map[string]interface{}{"mystruct": MyStruct{10, "x"}}},
+		Struct:  MyStruct{1, "a"},
+		StructP: &MyStruct{100, "test"},
+	}
+	b := ComplexStruct{
+		Map:     map[interface{}]interface{}{"x": map[string]interface{}{"mystruct": MyStruct{11, "x"}}},
+		Struct:  MyStruct{1, "a"},
+		StructP: &MyStruct{100, "test"},
+	}
+	assertDiff(t, a, b,
+		" equivalence.ComplexStruct<",
+		"   Map = interface:interface{",
+		`     "x" = string:interface{`,
+		`       "mystruct" = equivalence.MyStruct<`,
+		"-        IntVal = 10",
+		"+        IntVal = 11",
+		"         ... 1 equal field",
+		"       >",
+		"     }",
+		"   }",
+		"   ... 2 equal fields",
+		" >")
+}
+
+func TestDiffMissingKeys(t *testing.T) {
+	assertDiff(t, map[string]int{"a": 1, "b": 2}, map[string]int{"a": 1},
+		" string:int{",
+		"   // length mismatch: 2 vs 1",
+		`-  "b" = 2`,
+		"   ... 1 equal entry",
+		" }")
+}
+
+func TestDiffMissingNilKeys(t *testing.T) {
+	assertDiff(t, map[string]interface{}{"a": nil}, map[string]interface{}{"b": nil},
+		" string:interface{",
+		`+  "b" = nil`,
+		`-  "a" = nil`,
+		" }")
+}
+
+func TestDiffColor(t *testing.T) {
+	actual := Compare(1, 2).Diff(true)
+	expected := "\x1b[31m-1\x1b[0m\n\x1b[32m+2\x1b[0m\n"
+	if actual != expected {
+		t.Errorf("Expected %q but got %q", expected, actual)
+	}
+}
+
+func TestDiffStructFields(t *testing.T) {
+	assertDiff(t, MyStruct{1, "a"}, ExtendedStruct{1, "b", true},
+		" equivalence.MyStruct<",
+		"   ... 1 equal field",
+		`-  StringVal = "a"`,
+		`+  StringVal = "b"`,
+		"+  Extra = true",
+		" >")
+}
+
+func TestDiffUnordered(t *testing.T) {
+	actual := CompareWith([]int{1, 2, 3, 4}, []int{4, 3, 1, 5}, UnorderedSlices()).Diff(false)
+	expected := strings.Join([]string{
+		" int[",
+		"-  [1] = 2",
+		"+  [3] = 5",
+		"   ... 3 matched elements",
+		" ]",
+	}, "\n") + "\n"
+	if actual != expected {
+		t.Errorf("Expected diff:\n%v\nbut got:\n%v", expected, actual)
+	}
+}
+
+func TestDiffStructAndMap(t *testing.T) {
+	assertDiff(t, Address{"Paris", 1}, map[string]interface{}{"city": "Lyon", "Zip": 1},
+		" equivalence.Address<",
+		`-  City = "Paris"`,
+		`+  City = "Lyon"`,
+		"   ... 1 equal field",
+		" >")
+}
+
+func TestDiffMapOrderIsStable(t *testing.T) {
+	a := map[string]int{"d": 1, "b": 2, "a": 3, "c": 4}
+	b := map[string]int{"d": 5, "b": 6, "a": 7, "c": 8}
+	expected := strings.Join([]string{
+		" string:int{",
+		`-  "a" = 3`,
+		`+  "a" = 7`,
+		`-  "b" = 2`,
+		`+  "b" = 6`,
+		`-  "c" = 4`,
+		`+  "c" = 8`,
+		`-  "d" = 1`,
+		`+  "d" = 5`,
+		" }",
+	}, "\n") + "\n"
+	for i := 0; i < 20; i++ {
+		if actual := Diff(a, b); actual != expected {
+			t.Fatalf("Expected diff:\n%v\nbut got:\n%v", expected, actual)
+		}
+	}
+}
+
+func TestDiffUnorderedSameIndex(t *testing.T) {
+	result := CompareWith([]int{1, 2}, []int{1, 3}, UnorderedSlices())
+	if len(result.Differences) != 2 || result.Differences[0].Path != "[1]" || result.Differences[1].Path != "[B:1]" {
+		t.Errorf("Unexpected result %v", result)
+	}
+	actual := result.Diff(false)
+	expected := strings.Join([]string{
+		" int[",
+		"-  [1] = 2",
+		"+  [1] = 3",
+		"   ... 1 matched element",
+		" ]",
+	}, "\n") + "\n"
+	if actual != expected {
+		t.Errorf("Expected diff:\n%v\nbut got:\n%v", expected, actual)
+	}
+}
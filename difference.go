@@ -0,0 +1,375 @@
+package equivalence
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/kstenerud/go-describe"
+)
+
+// Reason describes why two values were found to not be equivalent.
+type Reason int
+
+const (
+	// The values are of types that cannot be compared to each other.
+	ReasonTypeMismatch Reason = iota + 1
+	// The containers have a different number of elements.
+	ReasonLengthMismatch
+	// A map key present on one side has no equivalent key on the other.
+	ReasonMissingKey
+	// The values are comparable, but are not equal.
+	ReasonValueDiffers
+	// The numeric values cannot be converted to each other's type without
+	// losing information (for example 1.5 vs an int, or -1 vs a uint).
+	ReasonLossyConversion
+	// A struct field in A has no field of the same name in B.
+	ReasonMissingField
+	// A struct field in B has no field of the same name in A.
+	ReasonExtraField
+	// In an unordered comparison, an element on one side has no equivalent
+	// element on the other.
+	ReasonUnmatchedElement
+	// With PreserveAliasing, an object on one side is referenced from
+	// somewhere that its counterpart on the other side is not.
+	ReasonAliasingDiffers
+	// Several keys in one map are equivalent to each other, so it's not
+	// possible to tell which of them to compare against the other map.
+	ReasonAmbiguousKey
+	// An array or slice element on one side is past the end of the shorter
+	// array or slice on the other side.
+	ReasonMissingElement
+	// Comparing the values panicked (for example in a comparer, an equality
+	// method, or a decimal type's conversion method). The note holds the value
+	// that was panicked with.
+	ReasonPanic
+)
+
+var reasonNames = map[Reason]string{
+	ReasonTypeMismatch:     "type mismatch",
+	ReasonLengthMismatch:   "length mismatch",
+	ReasonMissingKey:       "missing key",
+	ReasonValueDiffers:     "value differs",
+	ReasonLossyConversion:  "lossy numeric conversion",
+	ReasonMissingField:     "missing field",
+	ReasonExtraField:       "extra field",
+	ReasonUnmatchedElement: "unmatched element",
+	ReasonAliasingDiffers:  "aliasing differs",
+	ReasonAmbiguousKey:     "ambiguous key",
+	ReasonMissingElement:   "missing element",
+	ReasonPanic:            "comparison panicked",
+}
+
+func (_this Reason) String() string {
+	if name, ok := reasonNames[_this]; ok {
+		return name
+	}
+	return fmt.Sprintf("Reason(%d)", int(_this))
+}
+
+// Side is one of the two compared objects.
+type Side int
+
+const (
+	SideNeither Side = iota
+	SideA
+	SideB
+)
+
+func (_this Side) String() string {
+	switch _this {
+	case SideA:
+		return "A"
+	case SideB:
+		return "B"
+	}
+	return "neither"
+}
+
+// Difference is a single place where two objects were found to not be
+// equivalent.
+type Difference struct {
+	// Path to the differing values from the root of the compared objects,
+	// such as `.Struct.StructP.IntVal`, `["x"]["mystruct"]`, or `[3]`.
+	// The root itself has an empty path. In an unordered comparison, an element
+	// of B with no counterpart in A is at `[B:3]`, since its index refers to B.
+	Path string
+	// The values at Path. A value will be invalid (see reflect.Value.IsValid)
+	// if it is nil or doesn't exist on that side (see MissingFrom).
+	A reflect.Value
+	B reflect.Value
+	// The side that has nothing at Path to compare against, such as a map key
+	// or struct field that only exists on the other side, or SideNeither if
+	// both sides have a value (even if it's nil).
+	MissingFrom Side
+	// The types of the values at Path, or nil if the value is invalid.
+	AType reflect.Type
+	BType reflect.Type
+	// Why the values are not equivalent.
+	Reason Reason
+	// Extra information about the difference (such as the tolerance that the
+	// values fell outside of), or an empty string.
+	Note string
+
+	elements path
+}
+
+func newDifference(elements path, reason Reason, note string, a, b reflect.Value, missingFrom Side) Difference {
+	return Difference{
+		Path:        elements.String(),
+		A:           a,
+		B:           b,
+		MissingFrom: missingFrom,
+		AType:       typeOfValue(a),
+		BType:       typeOfValue(b),
+		Reason:      reason,
+		Note:        note,
+		elements:    append(path(nil), elements...),
+	}
+}
+
+// The reason, followed by the note (if any).
+func (_this Difference) describeReason() string {
+	if _this.Note == "" {
+		return _this.Reason.String()
+	}
+	return fmt.Sprintf("%v (%v)", _this.Reason, _this.Note)
+}
+
+func (_this Difference) String() string {
+	path := _this.Path
+	if path == "" {
+		path = "(root)"
+	}
+	return fmt.Sprintf("%v: %v: %v (%v) vs %v (%v)",
+		path, _this.describeReason(),
+		describeValue(_this.A), _this.AType,
+		describeValue(_this.B), _this.BType)
+}
+
+// Result is the outcome of comparing two objects using Compare.
+type Result struct {
+	// Every difference found between the two objects, in the order they were
+	// encountered. Empty if the objects are equivalent.
+	Differences []Difference
+}
+
+// Returns true if no differences were found.
+func (_this Result) IsEquivalent() bool {
+	return len(_this.Differences) == 0
+}
+
+func (_this Result) String() string {
+	if _this.IsEquivalent() {
+		return "equivalent"
+	}
+	buff := bytes.Buffer{}
+	for i, difference := range _this.Differences {
+		if i > 0 {
+			buff.WriteString("\n")
+		}
+		buff.WriteString(difference.String())
+	}
+	return buff.String()
+}
+
+func typeOfValue(v reflect.Value) reflect.Type {
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Type()
+}
+
+func describeValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	if !v.CanInterface() {
+		return describeUnreadableValue(v, 0)
+	}
+	switch getNumericClass(v) {
+	case numericClassBigInt, numericClassBigFloat, numericClassBigRat:
+		// go-describe can only see inside big numbers through a pointer.
+		if v.CanAddr() || v.CanInterface() {
+			return strings.TrimPrefix(describe.D(getPointerTo(v)), "*")
+		}
+	}
+	return describe.D(v)
+}
+
+type pathElementType int
+
+const (
+	pathElementIndex pathElementType = iota
+	pathElementField
+	pathElementMapKey
+	// An index into the b side only (an unmatched element of b)
+	pathElementIndexB
+)
+
+// Path elements are kept unformatted until a difference is actually recorded,
+// since most walks never need them.
+type pathElement struct {
+	elementType pathElementType
+	// Array/slice index or struct field index
+	index int
+	// Struct field name
+	name string
+	// Map key
+	key reflect.Value
+	// The containers that this element was taken from (used when rendering)
+	containerA reflect.Value
+	containerB reflect.Value
+}
+
+type path []pathElement
+
+func (_this path) String() string {
+	buff := bytes.Buffer{}
+	for _, element := range _this {
+		switch element.elementType {
+		case pathElementIndex:
+			buff.WriteString("[")
+			buff.WriteString(strconv.Itoa(element.index))
+			buff.WriteString("]")
+		case pathElementField:
+			buff.WriteString(".")
+			buff.WriteString(element.name)
+		case pathElementMapKey:
+			buff.WriteString("[")
+			buff.WriteString(describeValue(element.key))
+			buff.WriteString("]")
+		case pathElementIndexB:
+			buff.WriteString("[B:")
+			buff.WriteString(strconv.Itoa(element.index))
+			buff.WriteString("]")
+		}
+	}
+	return buff.String()
+}
+
+// Values taken from unexported fields are only copied or described down to
+// this depth, since they might be cyclic.
+const maxUnreadableDepth = 16
+
+// Describe a value taken from an unexported field, which go-describe can't
+// read. Where possible, it's copied into a readable value of the same type.
+// Otherwise, structs and pointers are described one level at a time in the
+// same format as go-describe.
+func describeUnreadableValue(v reflect.Value, depth int) string {
+	if readable, ok := getReadableCopy(v, depth); ok {
+		return describeValue(readable)
+	}
+	if depth < maxUnreadableDepth {
+		switch v.Kind() {
+		case reflect.Ptr:
+			return "*" + describeUnreadableValue(v.Elem(), depth+1)
+		case reflect.Interface:
+			return describeUnreadableValue(v.Elem(), depth+1)
+		case reflect.Struct:
+			fields := make([]string, v.NumField())
+			for i := range fields {
+				fields[i] = v.Type().Field(i).Name + "=" + describeUnreadableValue(v.Field(i), depth+1)
+			}
+			return fmt.Sprintf("%v<%v>", v.Type(), strings.Join(fields, " "))
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+// Copy a value taken from an unexported field into a readable value of the
+// same type, using only the accessors that reflect allows on such values.
+// This isn't possible for structs with unexported fields (other than
+// time.Time), channels, and functions.
+func getReadableCopy(v reflect.Value, depth int) (readable reflect.Value, ok bool) {
+	if !v.IsValid() || v.CanInterface() {
+		return v, true
+	}
+	if depth > maxUnreadableDepth {
+		return readable, false
+	}
+	t := v.Type()
+	if t == timeType {
+		return getReadableTime(v)
+	}
+	readable = reflect.New(t).Elem()
+	copyElement := func(to reflect.Value, from reflect.Value) bool {
+		element, ok := getReadableCopy(from, depth+1)
+		if ok && element.IsValid() {
+			to.Set(element)
+		}
+		return ok
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		readable.SetBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		readable.SetInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		readable.SetUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		readable.SetFloat(v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		readable.SetComplex(v.Complex())
+	case reflect.String:
+		readable.SetString(v.String())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !copyElement(readable.Index(i), v.Index(i)) {
+				return readable, false
+			}
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			break
+		}
+		readable.Set(reflect.MakeSlice(t, v.Len(), v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if !copyElement(readable.Index(i), v.Index(i)) {
+				return readable, false
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			break
+		}
+		readable.Set(reflect.MakeMap(t))
+		for _, k := range v.MapKeys() {
+			key := reflect.New(t.Key()).Elem()
+			value := reflect.New(t.Elem()).Elem()
+			if !copyElement(key, k) || !copyElement(value, v.MapIndex(k)) {
+				return readable, false
+			}
+			readable.SetMapIndex(key, value)
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			break
+		}
+		readable.Set(reflect.New(t.Elem()))
+		if !copyElement(readable.Elem(), v.Elem()) {
+			return readable, false
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			break
+		}
+		element, ok := getReadableCopy(v.Elem(), depth+1)
+		if !ok {
+			return readable, false
+		}
+		readable.Set(element)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).PkgPath != "" || !copyElement(readable.Field(i), v.Field(i)) {
+				return readable, false
+			}
+		}
+	default:
+		return readable, false
+	}
+	return readable, true
+}
@@ -12,9 +12,7 @@ import (
 	"math"
 	"math/big"
 	"reflect"
-	"strconv"
-
-	"github.com/kstenerud/go-duplicates"
+	"sort"
 )
 
 // Test if two objects are equivalent.
@@ -26,15 +24,43 @@ import (
 // interfaces, and the first concrete value of each object will be used for the
 // comparison.
 //
+// If either object implements Equivalent, or has an `Equal(T) bool` method that
+// accepts the other object, that method decides the comparison.
+//
 // The following numeric types will be converted (if an exact conversion is
-// possible) and numerically compared: int, uint, float, big.Int, big.Float
+// possible) and numerically compared: int, uint, float, big.Int, big.Float,
+// big.Rat, and any type implementing RationalNumber or BigFloatNumber (such as
+// decimal types). A complex number is equivalent to a non-complex number if
+// its imaginary part is zero and its real part is equivalent. Named string
+// types are equivalent to string.
 //
 // For slices, arrays, maps, and structs, it will compare elements. Element
-// values will not be drilled down.
+// values will not be drilled down. Struct fields are matched by name, so the
+// structs must have the same field names, but not necessarily in the same
+// order or with the same types. Fields can be ignored or renamed using struct
+// tags (`equivalence:"-"` and `equivalence:"name=foo"`). Map keys are
+// matched using the same rules, so map[int8]string{1: "a"} is equivalent to
+// map[MyInt]string{1: "a"}. If a map has several keys that are equivalent to
+// each other (such as int8(1) and int64(1), or pointers to equal values), the
+// other map must have the same number of such keys, and their values must pair
+// up one-to-one.
+// A struct can be compared against a map with string keys (such as one decoded
+// from JSON), with each exported field matched to the key named by its json or
+// yaml tag, or by its Go name.
 //
-// NaN values are considered equivalent, regardless of actual payload.
-// Empty containers are considered equivalent, regardless of element type.
-func IsEquivalent(a, b interface{}) (isEquivalent bool) {
+// NaN values are considered equivalent, regardless of actual payload, and
+// positive and negative zero are equivalent (see WithNaNPolicy and
+// DistinguishSignedZero to change this). The same rules apply to the
+// components of complex numbers.
+// Empty containers are considered equivalent, regardless of element type, so
+// an empty map is also equivalent to an empty array or slice.
+func IsEquivalent(a, b interface{}) bool {
+	return IsEquivalentWith(a, b)
+}
+
+// Test if two objects are equivalent, using the rules of IsEquivalent as
+// modified by opts.
+func IsEquivalentWith(a, b interface{}, opts ...Option) (isEquivalent bool) {
 	defer func() {
 		// The internal comparison functions just assume that the types are compatible,
 		// which causes panics when that's not actually the case. It's simpler
@@ -46,398 +72,875 @@ func IsEquivalent(a, b interface{}) (isEquivalent bool) {
 	if a == nil && b == nil {
 		return true
 	}
-	c := newComparator()
+	c := newComparator(newOptions(opts))
 	return c.areObjectsEquivalent(reflect.ValueOf(a), reflect.ValueOf(b))
 }
 
+// Compare two objects for equivalence, collecting every difference found.
+//
+// Compare follows the same rules as IsEquivalent, but rather than stopping at
+// the first mismatch, it keeps walking the objects and records where and why
+// each pair of values differs. result.IsEquivalent() will always agree with
+// IsEquivalent(a, b).
+func Compare(a, b interface{}) Result {
+	return CompareWith(a, b)
+}
+
+// Compare two objects for equivalence, using the rules of IsEquivalent as
+// modified by opts, and collecting every difference found.
+func CompareWith(a, b interface{}, opts ...Option) (result Result) {
+	c := newComparator(newOptions(opts))
+	c.result = &result
+	defer func() {
+		// See IsEquivalent. A panic is recorded at whatever path the walk had
+		// reached when it happened.
+		if r := recover(); r != nil {
+			c.failWithNote(ReasonPanic, fmt.Sprint(r), c.panicA, c.panicB)
+		}
+	}()
+	if a == nil && b == nil {
+		return
+	}
+	c.areObjectsEquivalent(reflect.ValueOf(a), reflect.ValueOf(b))
+	return
+}
+
 type comparator struct {
-	aFinder duplicates.DuplicateFinder
-	bFinder duplicates.DuplicateFinder
+	options options
+	// Pairs of objects that are currently being compared, and their depth
+	visiting map[visit]int
+	// Pairs of objects that have already been compared
+	visited map[visitContext]visitResult
+	// The number of pairs in visiting
+	visitDepth int
+	// The lowest depth of a pair in visiting that was reached again (a cycle)
+	// while comparing the current pair, or 0 if there was none.
+	lowestCycleDepth int
+	// Which objects have been paired up (see PreserveAliasing).
+	aliases aliasTracker
+	path    path
+	// Only set when differences are being collected (see Compare).
+	result *Result
+	// Set while comparing in isolation (see areElementsEquivalent).
+	isIsolated bool
+	// Set when the next value to be compared is a struct field tagged as
+	// unordered. Cleared by areObjectsEquivalent.
+	isUnorderedField bool
+	// The values most recently passed to areObjectsEquivalent, used to report
+	// the values involved if a comparison panics.
+	panicA reflect.Value
+	panicB reflect.Value
 }
 
-func newComparator() *comparator {
+func newComparator(opts options) *comparator {
 	_this := &comparator{}
-	_this.Init()
+	_this.Init(opts)
 	return _this
 }
 
-func (_this *comparator) Init() {
-	_this.aFinder.Init()
-	_this.bFinder.Init()
+func (_this *comparator) Init(opts options) {
+	_this.options = opts
+}
+
+// Returns true if the walk should continue after finding a difference.
+func (_this *comparator) isCollecting() bool {
+	return _this.result != nil
 }
 
-func getIntKeyedMapValue(aMap reflect.Value, aKey int64) reflect.Value {
-	initialResult := aMap.MapIndex(reflect.ValueOf(aKey))
-	if initialResult.IsValid() {
-		return initialResult
+// Record a difference at the current path (if collecting).
+// Always returns false so that callers can `return _this.fail(...)`.
+func (_this *comparator) fail(reason Reason, a, b reflect.Value) bool {
+	return _this.failWithNote(reason, "", a, b)
+}
+
+// Record a difference at the current path (if collecting), with extra
+// information about why the values differ.
+// Always returns false so that callers can `return _this.failWithNote(...)`.
+func (_this *comparator) failWithNote(reason Reason, note string, a, b reflect.Value) bool {
+	if _this.isCollecting() {
+		_this.result.Differences = append(_this.result.Differences,
+			newDifference(_this.path, reason, note, a, b, SideNeither))
 	}
-	asInt := int(aKey)
-	if int64(asInt) == aKey {
-		if v := aMap.MapIndex(reflect.ValueOf(asInt)); v.IsValid() {
-			return v
+	return false
+}
+
+// Record a difference at the current path (if collecting), where only one side
+// has a value (v), and the other side (missingFrom) has nothing.
+// Always returns false so that callers can `return _this.failMissing(...)`.
+func (_this *comparator) failMissing(reason Reason, note string, v reflect.Value, missingFrom Side) bool {
+	if _this.isCollecting() {
+		a, b := v, reflect.Value{}
+		if missingFrom == SideA {
+			a, b = b, a
 		}
+		_this.result.Differences = append(_this.result.Differences,
+			newDifference(_this.path, reason, note, a, b, missingFrom))
 	}
-	asInt32 := int32(aKey)
-	if int64(asInt32) == aKey {
-		if v := aMap.MapIndex(reflect.ValueOf(asInt32)); v.IsValid() {
-			return v
-		}
+	return false
+}
+
+// Compare substituteA and substituteB (such as converted forms of a and b)
+// using compare, but report any difference against a and b themselves.
+func (_this *comparator) compareSubstitutes(a, b, substituteA, substituteB reflect.Value, compare func(a, b reflect.Value) bool) bool {
+	result := _this.result
+	var substituteResult Result
+	if result != nil {
+		_this.result = &substituteResult
 	}
-	asInt16 := int16(aKey)
-	if int64(asInt16) == aKey {
-		if v := aMap.MapIndex(reflect.ValueOf(asInt16)); v.IsValid() {
-			return v
-		}
+	// Restore the result even if compare panics, so that the panic is
+	// recorded where it will be seen.
+	defer func() {
+		_this.result = result
+	}()
+	isEquivalent := compare(substituteA, substituteB)
+	_this.result = result
+	for _, difference := range substituteResult.Differences {
+		_this.failWithNote(difference.Reason, difference.Note, a, b)
 	}
-	asInt8 := int8(aKey)
-	if int64(asInt8) == aKey {
-		if v := aMap.MapIndex(reflect.ValueOf(asInt8)); v.IsValid() {
-			return v
-		}
+	return isEquivalent
+}
+
+func (_this *comparator) pushIndex(a, b reflect.Value, index int) {
+	_this.path = append(_this.path, pathElement{
+		elementType: pathElementIndex,
+		index:       index,
+		containerA:  a,
+		containerB:  b,
+	})
+}
+
+// Push the index of an element that only exists in b.
+func (_this *comparator) pushIndexB(a, b reflect.Value, index int) {
+	_this.path = append(_this.path, pathElement{
+		elementType: pathElementIndexB,
+		index:       index,
+		containerA:  a,
+		containerB:  b,
+	})
+}
+
+// index is the field's position in a (fields that only exist in b are
+// positioned after all of a's fields).
+func (_this *comparator) pushField(a, b reflect.Value, name string, index int) {
+	_this.path = append(_this.path, pathElement{
+		elementType: pathElementField,
+		index:       index,
+		name:        name,
+		containerA:  a,
+		containerB:  b,
+	})
+}
+
+func (_this *comparator) pushMapKey(a, b reflect.Value, key reflect.Value) {
+	_this.path = append(_this.path, pathElement{
+		elementType: pathElementMapKey,
+		key:         unwrapInterface(key),
+		containerA:  a,
+		containerB:  b,
+	})
+}
+
+func (_this *comparator) popPath() {
+	_this.path = _this.path[:len(_this.path)-1]
+}
+
+func unwrapInterface(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Interface {
+		return v.Elem()
 	}
-	return initialResult
+	return v
 }
 
-func getUintKeyedMapValue(aMap reflect.Value, aKey uint64) reflect.Value {
-	initialResult := aMap.MapIndex(reflect.ValueOf(aKey))
-	if initialResult.IsValid() {
-		return initialResult
+// Get the keys of m. When collecting differences, the keys are sorted by their
+// description so that the differences are always reported in the same order.
+func (_this *comparator) getMapKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	if !_this.isCollecting() {
+		return keys
 	}
-	asUint := uint(aKey)
-	if uint64(asUint) == aKey {
-		if v := aMap.MapIndex(reflect.ValueOf(asUint)); v.IsValid() {
-			return v
-		}
+	descriptions := make([]string, len(keys))
+	for i, k := range keys {
+		descriptions[i] = describeValue(unwrapInterface(k))
 	}
-	asUint32 := uint32(aKey)
-	if uint64(asUint32) == aKey {
-		if v := aMap.MapIndex(reflect.ValueOf(asUint32)); v.IsValid() {
-			return v
-		}
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return descriptions[order[i]] < descriptions[order[j]]
+	})
+	sorted := make([]reflect.Value, len(keys))
+	for i, k := range order {
+		sorted[i] = keys[k]
 	}
-	asUint16 := uint16(aKey)
-	if uint64(asUint16) == aKey {
-		if v := aMap.MapIndex(reflect.ValueOf(asUint16)); v.IsValid() {
-			return v
+	return sorted
+}
+
+func (_this *comparator) areArraysOrSlicesEquivalent(a, b reflect.Value) bool {
+	isEquivalent := true
+	commonLength := a.Len()
+	if a.Len() != b.Len() {
+		isEquivalent = _this.fail(ReasonLengthMismatch, a, b)
+		if !_this.isCollecting() {
+			return false
+		}
+		if b.Len() < commonLength {
+			commonLength = b.Len()
 		}
 	}
-	asUint8 := uint8(aKey)
-	if uint64(asUint8) == aKey {
-		if v := aMap.MapIndex(reflect.ValueOf(asUint8)); v.IsValid() {
-			return v
+	for i := 0; i < commonLength; i++ {
+		_this.pushIndex(a, b, i)
+		if !_this.areObjectsEquivalent(a.Index(i), b.Index(i)) {
+			isEquivalent = false
+		}
+		_this.popPath()
+		if !isEquivalent && !_this.isCollecting() {
+			return false
 		}
 	}
-	return initialResult
+
+	// Only reached when collecting
+	for i := commonLength; i < a.Len(); i++ {
+		_this.pushIndex(a, b, i)
+		_this.failMissing(ReasonMissingElement, "", unwrapInterface(a.Index(i)), SideB)
+		_this.popPath()
+	}
+	for i := commonLength; i < b.Len(); i++ {
+		_this.pushIndex(a, b, i)
+		_this.failMissing(ReasonMissingElement, "", unwrapInterface(b.Index(i)), SideA)
+		_this.popPath()
+	}
+	return isEquivalent
 }
 
-func getFloatKeyedMapValue(aMap reflect.Value, aKey float64) reflect.Value {
-	initialResult := aMap.MapIndex(reflect.ValueOf(aKey))
-	if initialResult.IsValid() {
-		return initialResult
+// Returns true if a map value that only exists on one side should be treated
+// as if the other map contained its zero value (see MissingMapKeysAsZero).
+func (_this *comparator) isZeroForMissingKey(v reflect.Value, otherMap reflect.Value) bool {
+	if !_this.options.missingMapKeysAsZero {
+		return false
 	}
-	asFloat32 := float32(aKey)
-	if float64(asFloat32) == aKey {
-		if v := aMap.MapIndex(reflect.ValueOf(asFloat32)); v.IsValid() {
-			return v
+	zero := reflect.Zero(otherMap.Type().Elem())
+	if zero.Kind() == reflect.Interface {
+		// Compare against the zero value of whatever type v actually holds.
+		concrete, _ := drillDown(v)
+		if !concrete.IsValid() {
+			return true
 		}
+		zero = reflect.Zero(concrete.Type())
 	}
-	return initialResult
+	return _this.areElementsEquivalent(v, zero)
 }
 
-func getMapValue(aMap reflect.Value, aKey reflect.Value) reflect.Value {
-	if aKey.Kind() == reflect.Interface {
-		aKey = aKey.Elem()
+func (_this *comparator) areMapsEquivalent(a, b reflect.Value) bool {
+	isEquivalent := true
+	if a.Len() != b.Len() && !_this.options.missingMapKeysAsZero {
+		isEquivalent = _this.fail(ReasonLengthMismatch, a, b)
+		if !_this.isCollecting() {
+			return false
+		}
 	}
 
-	initialResult := aMap.MapIndex(aKey)
-	if initialResult.IsValid() {
-		return initialResult
+	if _this.haveSamePlainKeyType(a, b) {
+		return _this.arePlainKeyedMapsEquivalent(a, b, isEquivalent)
+	}
+	if !_this.isCollecting() && !_this.options.preserveAliasing &&
+		a.Type().Key() == b.Type().Key() && a.Type().Key().Kind() == reflect.Interface &&
+		_this.areMapsEquivalentByIdenticalKeys(a, b) {
+		return true
 	}
 
-	switch aKey.Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		asInt := aKey.Int()
-		if v := getIntKeyedMapValue(aMap, asInt); v.IsValid() {
-			return v
+	aIndex := _this.newMapKeyIndex(a)
+	bIndex := _this.newMapKeyIndex(b)
+	if !_this.reportAmbiguousKeys(a, b, aIndex, bIndex) {
+		isEquivalent = false
+		if !_this.isCollecting() {
+			return false
 		}
+	}
 
-		if asInt >= 0 {
-			if v := getUintKeyedMapValue(aMap, uint64(asInt)); v.IsValid() {
-				return v
+	if a.Len() != b.Len() || _this.options.missingMapKeysAsZero || len(aIndex.ambiguousGroups) > 0 || _this.isCollecting() {
+		// Check the keys in b that have no counterpart in a, since walking a
+		// alone won't find them. Otherwise, if the maps are the same size, a
+		// key missing from a means that one is also missing from b.
+		for i, k := range bIndex.all {
+			if bIndex.isAmbiguous(i) {
+				continue
 			}
-		}
-		return getFloatKeyedMapValue(aMap, float64(asInt))
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		asUint := aKey.Uint()
-		if v := getUintKeyedMapValue(aMap, asUint); v.IsValid() {
-			return v
-		}
-		if asUint <= math.MaxInt64 {
-			if v := getIntKeyedMapValue(aMap, int64(asUint)); v.IsValid() {
-				return v
+			if _, ok := _this.findMapKey(aIndex, k); ok {
+				continue
 			}
-		}
-		return getFloatKeyedMapValue(aMap, float64(asUint))
-	case reflect.Float32, reflect.Float64:
-		asFloat := aKey.Float()
-		if asInt := int64(asFloat); float64(asInt) == asFloat {
-			if v := getIntKeyedMapValue(aMap, asInt); v.IsValid() {
-				return v
+			bv := b.MapIndex(k)
+			if _this.isZeroForMissingKey(bv, a) {
+				continue
 			}
-		}
-		if asUint := uint64(asFloat); float64(asUint) == asFloat {
-			if v := getUintKeyedMapValue(aMap, asUint); v.IsValid() {
-				return v
+			_this.pushMapKey(a, b, k)
+			isEquivalent = _this.failMissing(ReasonMissingKey, "", unwrapInterface(bv), SideA)
+			_this.popPath()
+			if !_this.isCollecting() {
+				return false
 			}
 		}
-	default:
 	}
-	return initialResult
-}
 
-func (_this *comparator) areArraysOrSlicesEquivalent(a, b reflect.Value) bool {
-	if a.Len() != b.Len() {
-		return false
-	}
-	for i := 0; i < a.Len(); i++ {
-		if !_this.areObjectsEquivalent(a.Index(i), b.Index(i)) {
+	for i, k := range aIndex.all {
+		if aIndex.isAmbiguous(i) {
+			continue
+		}
+		av := a.MapIndex(k)
+		bKeyIndex, ok := _this.findMapKey(bIndex, k)
+		if ok && bIndex.isAmbiguous(bKeyIndex) {
+			// Already reported
+			continue
+		}
+		_this.pushMapKey(a, b, k)
+		if !ok {
+			if !_this.isZeroForMissingKey(av, b) {
+				isEquivalent = _this.failMissing(ReasonMissingKey, "", unwrapInterface(av), SideB)
+			}
+		} else if !_this.areObjectsEquivalent(av, b.MapIndex(bIndex.all[bKeyIndex])) {
+			isEquivalent = false
+		}
+		_this.popPath()
+		if !isEquivalent && !_this.isCollecting() {
 			return false
 		}
 	}
-	return true
+	return isEquivalent
 }
 
-func (_this *comparator) areMapsEquivalent(a, b reflect.Value) bool {
-	if a.Len() != b.Len() {
-		return false
+// Compare maps that share a plain key type (see plainKeyType), looking up each
+// key directly in the other map. isEquivalent is the result so far.
+func (_this *comparator) arePlainKeyedMapsEquivalent(a, b reflect.Value, isEquivalent bool) bool {
+	if a.Len() != b.Len() || _this.options.missingMapKeysAsZero || _this.isCollecting() {
+		for _, k := range _this.getMapKeys(b) {
+			if a.MapIndex(k).IsValid() {
+				continue
+			}
+			bv := b.MapIndex(k)
+			if _this.isZeroForMissingKey(bv, a) {
+				continue
+			}
+			_this.pushMapKey(a, b, k)
+			isEquivalent = _this.failMissing(ReasonMissingKey, "", unwrapInterface(bv), SideA)
+			_this.popPath()
+			if !_this.isCollecting() {
+				return false
+			}
+		}
 	}
-	iter := mapRange(a)
-	for iter.Next() {
-		k := iter.Key()
-		av := iter.Value()
-		bv := getMapValue(b, k)
-		if !_this.areObjectsEquivalent(av, bv) {
+
+	for _, k := range _this.getMapKeys(a) {
+		av := a.MapIndex(k)
+		bv := b.MapIndex(k)
+		_this.pushMapKey(a, b, k)
+		if !bv.IsValid() {
+			if !_this.isZeroForMissingKey(av, b) {
+				isEquivalent = _this.failMissing(ReasonMissingKey, "", unwrapInterface(av), SideB)
+			}
+		} else if !_this.areObjectsEquivalent(av, bv) {
+			isEquivalent = false
+		}
+		_this.popPath()
+		if !isEquivalent && !_this.isCollecting() {
 			return false
 		}
 	}
-	return true
+	return isEquivalent
 }
 
 var bigIntType = reflect.TypeOf(big.Int{})
 var bigFloatType = reflect.TypeOf(big.Float{})
-var bitsToDecimalDigitsTable = []int{0, 1, 1, 1, 1, 2, 2, 2, 3, 3}
-
-func bitsToDecimalDigits(bitCount int) int {
-	return (bitCount/10)*3 + bitsToDecimalDigitsTable[bitCount%10]
-}
-
-func bigFloatToString(v big.Float) string {
-	return v.Text('g', bitsToDecimalDigits(int(v.Prec())))
-}
-
-func floatToString(v float64) string {
-	return strconv.FormatFloat(v, 'g', -1, 64)
-}
-
-func areBigFloatsEquivalent(a, b reflect.Value) bool {
-	return bigFloatToString(a.Interface().(big.Float)) == bigFloatToString(b.Interface().(big.Float))
-}
+var bigRatType = reflect.TypeOf(big.Rat{})
 
 func (_this *comparator) areStructsEquivalent(a, b reflect.Value) bool {
-	switch a.Type() {
-	case bigIntType:
-		return isEquivalentToBigInt(a.Interface().(big.Int), b)
-	case bigFloatType:
-		return isEquivalentToBigFloat(a.Interface().(big.Float), b)
+	if _this.options.matchStructFieldsByPosition || a.Type() == b.Type() {
+		return _this.areStructFieldsEquivalentByPosition(a, b)
 	}
+	return _this.areStructFieldsEquivalentByName(a, b)
+}
 
+func (_this *comparator) areStructFieldsEquivalentByPosition(a, b reflect.Value) bool {
 	if a.NumField() != b.NumField() {
-		return false
+		return _this.fail(ReasonTypeMismatch, a, b)
 	}
-	for i := 0; i < a.NumField(); i++ {
+	isEquivalent := true
+	aFields := getStructFields(a.Type())
+	bFields := getStructFields(b.Type())
+	for i, aField := range aFields {
+		if aField.isIgnored || bFields[i].isIgnored {
+			continue
+		}
+		_this.pushField(a, b, aField.name, i)
+		_this.isUnorderedField = aField.isUnordered || bFields[i].isUnordered
 		if !_this.areObjectsEquivalent(a.Field(i), b.Field(i)) {
+			isEquivalent = false
+		}
+		_this.popPath()
+		if !isEquivalent && !_this.isCollecting() {
+			return false
+		}
+	}
+	return isEquivalent
+}
+
+func (_this *comparator) areStructFieldsEquivalentByName(a, b reflect.Value) bool {
+	isEquivalent := true
+	aFields := getStructFields(a.Type())
+	bFields := getStructFields(b.Type())
+	for _, aField := range aFields {
+		if aField.isIgnored {
+			continue
+		}
+		_this.pushField(a, b, aField.name, aField.index)
+		if bField := findStructField(bFields, aField.matchName); bField == nil {
+			isEquivalent = _this.failMissing(ReasonMissingField, "", a.Field(aField.index), SideB)
+		} else {
+			_this.isUnorderedField = aField.isUnordered || bField.isUnordered
+			if !_this.areObjectsEquivalent(a.Field(aField.index), b.Field(bField.index)) {
+				isEquivalent = false
+			}
+		}
+		_this.popPath()
+		if !isEquivalent && !_this.isCollecting() {
+			return false
+		}
+	}
+
+	extraIndex := a.NumField()
+	for _, bField := range bFields {
+		if bField.isIgnored || findStructField(aFields, bField.matchName) != nil {
+			continue
+		}
+		_this.pushField(a, b, bField.name, extraIndex)
+		isEquivalent = _this.failMissing(ReasonExtraField, "", b.Field(bField.index), SideA)
+		_this.popPath()
+		if !_this.isCollecting() {
 			return false
 		}
+		extraIndex++
 	}
-	return true
+	return isEquivalent
 }
 
-func numericToString(v reflect.Value) string {
+type numericClass int
+
+const (
+	numericClassNone numericClass = iota
+	numericClassInt
+	numericClassUint
+	numericClassFloat
+	numericClassBigInt
+	numericClassBigFloat
+	numericClassBigRat
+	// A type implementing RationalNumber or BigFloatNumber
+	numericClassDecimal
+)
+
+func getNumericClass(v reflect.Value) numericClass {
+	if v.IsValid() && isDecimal(v) {
+		return numericClassDecimal
+	}
 	switch v.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return strconv.FormatInt(v.Int(), 10)
+		return numericClassInt
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return strconv.FormatUint(v.Uint(), 10)
+		return numericClassUint
 	case reflect.Float32, reflect.Float64:
-		return floatToString(v.Float())
+		return numericClassFloat
 	case reflect.Struct:
+		if !v.CanInterface() {
+			// Big numbers taken from unexported fields can't be read, and are
+			// compared like any other struct.
+			return numericClassNone
+		}
 		switch v.Type() {
 		case bigIntType:
-			val := v.Interface().(big.Int)
-			return val.String()
+			return numericClassBigInt
 		case bigFloatType:
-			val := v.Interface().(big.Float)
-			return bigFloatToString(val)
+			return numericClassBigFloat
+		case bigRatType:
+			return numericClassBigRat
 		}
 	}
-	return fmt.Sprintf("NOT NUMERIC: %v", v)
+	return numericClassNone
 }
 
-func isEquivalentToBigFloat(a big.Float, b reflect.Value) bool {
-	return bigFloatToString(a) == numericToString(b)
+// Returns true if numeric value v can be converted to a type of class c
+// without losing information.
+func canConvertExactly(v reflect.Value, c numericClass) bool {
+	if isNaNValue(v) {
+		return c == numericClassFloat
+	}
+	if getInfinitySign(v) != 0 {
+		return isFloatClass(c)
+	}
+	r := numericToRat(v)
+	switch c {
+	case numericClassInt:
+		return r.IsInt() && r.Num().IsInt64()
+	case numericClassUint:
+		return r.IsInt() && r.Num().IsUint64()
+	case numericClassFloat:
+		_, isExact := r.Float64()
+		return isExact
+	case numericClassBigInt:
+		return r.IsInt()
+	case numericClassBigFloat:
+		// Only fractions with a power of 2 denominator are representable.
+		denominator := r.Denom()
+		return new(big.Int).And(denominator, new(big.Int).Sub(denominator, big.NewInt(1))).Sign() == 0
+	case numericClassBigRat, numericClassDecimal:
+		return true
+	}
+	return false
 }
 
-func isEquivalentToBigInt(a big.Int, b reflect.Value) bool {
-	return a.String() == numericToString(b)
+func isFloatClass(c numericClass) bool {
+	return c == numericClassFloat || c == numericClassBigFloat
 }
 
-func isEquivalentToInt(a int64, b reflect.Value) bool {
-	switch b.Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return b.Int() == a
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		ub := b.Uint()
-		if ub&0x1000000000000000 != 0 {
-			return false
+// Returns the sign of numeric value v if it's infinite, or 0 otherwise.
+func getInfinitySign(v reflect.Value) int {
+	switch getNumericClass(v) {
+	case numericClassFloat:
+		f := v.Float()
+		if math.IsInf(f, 1) {
+			return 1
 		}
-		return a == int64(ub)
-	case reflect.Float32, reflect.Float64:
-		fb := b.Float()
-		return a == int64(fb) && float64(a) == fb
-	case reflect.Struct:
-		switch b.Type() {
-		case bigIntType:
-			bi := b.Interface().(big.Int)
-			return strconv.FormatInt(a, 10) == bi.String()
-		case bigFloatType:
-			return strconv.FormatInt(a, 10) == bigFloatToString(b.Interface().(big.Float))
+		if math.IsInf(f, -1) {
+			return -1
+		}
+	case numericClassBigFloat:
+		bf := v.Interface().(big.Float)
+		if bf.IsInf() {
+			return bf.Sign()
+		}
+	case numericClassDecimal:
+		if _, f := getDecimalValue(v); f != nil && f.IsInf() {
+			return f.Sign()
 		}
-		return false
-	default:
-		return false
 	}
+	return 0
 }
 
-func isEquivalentToUint(a uint64, b reflect.Value) bool {
-	switch b.Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		ib := b.Int()
-		if ib < 0 {
-			return false
+// Convert a finite, non-NaN numeric value to a big.Rat without loss.
+func numericToRat(v reflect.Value) *big.Rat {
+	switch getNumericClass(v) {
+	case numericClassInt:
+		return new(big.Rat).SetInt64(v.Int())
+	case numericClassUint:
+		return new(big.Rat).SetInt(new(big.Int).SetUint64(v.Uint()))
+	case numericClassFloat:
+		return new(big.Rat).SetFloat64(v.Float())
+	case numericClassBigInt:
+		bi := v.Interface().(big.Int)
+		return new(big.Rat).SetInt(&bi)
+	case numericClassBigFloat:
+		bf := v.Interface().(big.Float)
+		r, _ := bf.Rat(nil)
+		return r
+	case numericClassBigRat:
+		br := v.Interface().(big.Rat)
+		return new(big.Rat).Set(&br)
+	case numericClassDecimal:
+		r, f := getDecimalValue(v)
+		if f != nil {
+			r, _ = f.Rat(nil)
 		}
-		return a == uint64(ib)
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return a == b.Uint()
-	case reflect.Float32, reflect.Float64:
-		fb := b.Float()
-		if fb < 0 {
-			return false
+		return r
+	}
+	return nil
+}
+
+func isIntEqualToUint(i int64, u uint64) bool {
+	return i >= 0 && uint64(i) == u
+}
+
+func isFloatEqualToInt(f float64, i int64) bool {
+	// -2^63 is exactly representable as a float64, but 2^63-1 isn't.
+	return f == math.Trunc(f) && f >= -(1<<63) && f < 1<<63 && int64(f) == i
+}
+
+func isFloatEqualToUint(f float64, u uint64) bool {
+	return f == math.Trunc(f) && f >= 0 && f < 1<<64 && uint64(f) == u
+}
+
+// Test if numeric values a and b have exactly the same value. NaN must already
+// have been dealt with (see compareSpecialNumbers).
+func areNumbersExactlyEquivalent(a, b reflect.Value) bool {
+	aClass := getNumericClass(a)
+	bClass := getNumericClass(b)
+
+	// Fast paths for the built-in types
+	switch {
+	case aClass == numericClassInt && bClass == numericClassInt:
+		return a.Int() == b.Int()
+	case aClass == numericClassUint && bClass == numericClassUint:
+		return a.Uint() == b.Uint()
+	case aClass == numericClassFloat && bClass == numericClassFloat:
+		return a.Float() == b.Float()
+	case aClass == numericClassInt && bClass == numericClassUint:
+		return isIntEqualToUint(a.Int(), b.Uint())
+	case aClass == numericClassUint && bClass == numericClassInt:
+		return isIntEqualToUint(b.Int(), a.Uint())
+	case aClass == numericClassFloat && bClass == numericClassInt:
+		return isFloatEqualToInt(a.Float(), b.Int())
+	case aClass == numericClassInt && bClass == numericClassFloat:
+		return isFloatEqualToInt(b.Float(), a.Int())
+	case aClass == numericClassFloat && bClass == numericClassUint:
+		return isFloatEqualToUint(a.Float(), b.Uint())
+	case aClass == numericClassUint && bClass == numericClassFloat:
+		return isFloatEqualToUint(b.Float(), a.Uint())
+	}
+
+	aInfinitySign := getInfinitySign(a)
+	bInfinitySign := getInfinitySign(b)
+	if aInfinitySign != 0 || bInfinitySign != 0 {
+		return aInfinitySign == bInfinitySign
+	}
+	return numericToRat(a).Cmp(numericToRat(b)) == 0
+}
+
+func (_this *comparator) areNumbersEquivalent(a, b reflect.Value) bool {
+	if _this.shouldParseNumericString(a, b) {
+		return _this.areNumericStringAndNumberEquivalent(a, b)
+	}
+	if isComplex(a) && getNumericClass(b) != numericClassNone ||
+		isComplex(b) && getNumericClass(a) != numericClassNone {
+		return _this.areComplexAndNumberEquivalent(a, b)
+	}
+	if getNumericClass(a) == numericClassNone || getNumericClass(b) == numericClassNone {
+		return _this.fail(ReasonTypeMismatch, a, b)
+	}
+	if isEquivalent, ok := _this.compareSpecialNumbers(a, b); ok {
+		if !isEquivalent {
+			return _this.fail(ReasonValueDiffers, a, b)
 		}
-		return a == uint64(fb) && float64(a) == fb
-	case reflect.Struct:
-		switch b.Type() {
-		case bigIntType:
-			bi := b.Interface().(big.Int)
-			return strconv.FormatUint(a, 10) == bi.String()
-		case bigFloatType:
-			return strconv.FormatUint(a, 10) == bigFloatToString(b.Interface().(big.Float))
+		return true
+	}
+	if areNumbersExactlyEquivalent(a, b) {
+		return true
+	}
+
+	note := ""
+	tolerance := &_this.options.floatTolerance
+	if tolerance.isEnabled() && (isFloatClass(getNumericClass(a)) || isFloatClass(getNumericClass(b))) {
+		if tolerance.isWithinTolerance(a, b) {
+			return true
 		}
+		note = "outside " + tolerance.String()
+	}
+
+	// Choose between a lossy conversion and a plain value difference.
+	aClass := getNumericClass(a)
+	bClass := getNumericClass(b)
+	if aClass != bClass && !(canConvertExactly(a, bClass) && canConvertExactly(b, aClass)) {
+		return _this.failWithNote(ReasonLossyConversion, note, a, b)
+	}
+	return _this.failWithNote(ReasonValueDiffers, note, a, b)
+}
+
+// Compare two values where at least one is a big.Int, big.Float, or big.Rat.
+// Big numbers taken from unexported fields can't be read, so two of the same
+// type are compared as structs instead.
+func (_this *comparator) areBigNumbersEquivalent(a, b reflect.Value) bool {
+	if (!a.CanInterface() || !b.CanInterface()) && a.Type() == b.Type() {
+		return _this.areStructsEquivalent(a, b)
+	}
+	return _this.areNumbersEquivalent(a, b)
+}
+
+func isNilContainer(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.IsNil()
+	}
+	return false
+}
+
+type kindClass int
+
+const (
+	kindClassOther kindClass = iota
+	kindClassNumeric
+	kindClassComplex
+	kindClassArrayOrSlice
+)
+
+func getKindClass(v reflect.Value) kindClass {
+	if getNumericClass(v) != numericClassNone {
+		return kindClassNumeric
+	}
+	switch v.Kind() {
+	case reflect.Complex64, reflect.Complex128:
+		return kindClassComplex
+	case reflect.Array, reflect.Slice:
+		return kindClassArrayOrSlice
+	}
+	return kindClassOther
+}
+
+// Returns true if a and b are of kinds that the comparison functions know how
+// to compare against each other.
+func areKindsComparable(a, b reflect.Value) bool {
+	aClass := getKindClass(a)
+	bClass := getKindClass(b)
+	if aClass != bClass {
+		// Complex numbers can be compared to other numbers.
+		return (aClass == kindClassComplex && bClass == kindClassNumeric) ||
+			(aClass == kindClassNumeric && bClass == kindClassComplex)
+	}
+	return aClass != kindClassOther || a.Kind() == b.Kind()
+}
+
+// Returns true if one of a and b is an empty map, and the other is an empty
+// array or slice. Empty containers are equivalent regardless of their kinds.
+func isEmptyMapAndList(a, b reflect.Value) bool {
+	if b.Kind() == reflect.Map {
+		a, b = b, a
+	}
+	return a.Kind() == reflect.Map && getKindClass(b) == kindClassArrayOrSlice &&
+		a.Len() == 0 && b.Len() == 0
+}
+
+func (_this *comparator) areObjectsEquivalent(a, b reflect.Value) bool {
+	isUnorderedField := _this.isUnorderedField
+	_this.isUnorderedField = false
+
+	var aWasPointer, bWasPointer bool
+	a, aWasPointer = drillDown(a)
+	b, bWasPointer = drillDown(b)
+
+	if !_this.checkAliasing(a, b, aWasPointer, bWasPointer) {
 		return false
-	default:
-		return false
 	}
+
+	if key, ok := getVisit(a, b, aWasPointer || bWasPointer); ok {
+		return _this.areVisitedValuesEquivalent(key, a, b, isUnorderedField)
+	}
+	return _this.areValuesEquivalent(a, b, isUnorderedField)
 }
 
-func isEquivalentToFloat(a float64, b reflect.Value) bool {
-	switch b.Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		ib := b.Int()
-		return a == float64(ib) && int64(a) == ib
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		ub := b.Uint()
-		return a == float64(ub) && uint64(a) == ub
-	case reflect.Float32, reflect.Float64:
-		fb := b.Float()
-		if math.IsNaN(a) && math.IsNaN(fb) {
+// Compare values that have already been drilled down to.
+func (_this *comparator) areValuesEquivalent(a, b reflect.Value, isUnorderedField bool) bool {
+	if !a.IsValid() || !b.IsValid() {
+		// Special case: zero value
+		if !a.IsValid() && !b.IsValid() {
 			return true
 		}
-		return a == fb
-	case reflect.Struct:
-		switch b.Type() {
-		case bigIntType:
-			bi := b.Interface().(big.Int)
-			return floatToString(a) == bi.String()
-		case bigFloatType:
-			return floatToString(a) == bigFloatToString(b.Interface().(big.Float))
+		return _this.fail(ReasonValueDiffers, a, b)
+	}
+
+	if !_this.areTypesStrictlyCompatible(a, b) {
+		return _this.fail(ReasonTypeMismatch, a, b)
+	}
+
+	if compare := _this.lookupComparer(a, b); compare != nil {
+		_this.panicA, _this.panicB = a, b
+		return compare(_this, a, b)
+	}
+
+	if isEquivalent, ok := _this.tryEqualityMethods(a, b); ok {
+		if !isEquivalent {
+			return _this.fail(ReasonValueDiffers, a, b)
 		}
-		return false
-	default:
-		return false
+		return true
 	}
-}
 
-func (_this *comparator) areObjectsEquivalent(a, b reflect.Value) bool {
-	var aHasDuplicate, bHasDuplicate bool
-	a, aHasDuplicate = drillDown(&_this.aFinder, a)
-	b, bHasDuplicate = drillDown(&_this.bFinder, b)
+	if _this.shouldParseNumericString(a, b) {
+		_this.panicA, _this.panicB = a, b
+		return _this.areNumericStringAndNumberEquivalent(a, b)
+	}
 
-	if aHasDuplicate || bHasDuplicate {
+	if _this.shouldCompareAsText(a, b) {
+		_this.panicA, _this.panicB = a, b
+		return _this.areTextsEquivalent(a, b, isUnorderedField)
+	}
+
+	if _this.isStructAndMap(a, b) {
+		_this.panicA, _this.panicB = a, b
+		return _this.areStructAndMapEquivalent(a, b)
+	}
+
+	isEmptyMapAndList := isEmptyMapAndList(a, b)
+	if !isEmptyMapAndList && !areKindsComparable(a, b) {
+		return _this.fail(ReasonTypeMismatch, a, b)
+	}
+
+	_this.panicA, _this.panicB = a, b
+
+	if _this.options.distinguishNilFromEmpty && isNilContainer(a) != isNilContainer(b) {
+		return _this.fail(ReasonValueDiffers, a, b)
+	}
+
+	if isEmptyMapAndList {
 		return true
 	}
 
-	if !a.IsValid() || !b.IsValid() {
-		// Special case: zero value
-		return !a.IsValid() && !b.IsValid()
+	if getKindClass(a) == kindClassNumeric || getKindClass(b) == kindClassNumeric {
+		return _this.areNumbersEquivalent(a, b)
 	}
 
 	switch a.Kind() {
 	case reflect.Bool:
-		return a.Bool() == b.Bool()
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return isEquivalentToInt(a.Int(), b)
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return isEquivalentToUint(a.Uint(), b)
-	case reflect.Float32, reflect.Float64:
-		return isEquivalentToFloat(a.Float(), b)
+		if a.Bool() != b.Bool() {
+			return _this.fail(ReasonValueDiffers, a, b)
+		}
+		return true
 	case reflect.Complex64, reflect.Complex128:
-		return a.Complex() == b.Complex()
+		return _this.areComplexesEquivalent(a, b)
 	case reflect.String:
-		return a.Type() == b.Type() && a.String() == b.String()
+		if a.String() != b.String() {
+			return _this.fail(ReasonValueDiffers, a, b)
+		}
+		return true
 	case reflect.Array:
+		if _this.isUnorderedAtCurrentPath(isUnorderedField) {
+			return _this.areArraysOrSlicesEquivalentUnordered(a, b)
+		}
 		return _this.areArraysOrSlicesEquivalent(a, b)
 	case reflect.Slice:
-		if hasDuplicate := _this.aFinder.RegisterPointer(a); hasDuplicate {
-			return true
+		if _this.isUnorderedAtCurrentPath(isUnorderedField) {
+			return _this.areArraysOrSlicesEquivalentUnordered(a, b)
 		}
 		return _this.areArraysOrSlicesEquivalent(a, b)
 	case reflect.Map:
-		if hasDuplicate := _this.aFinder.RegisterPointer(a); hasDuplicate {
-			return true
-		}
 		return _this.areMapsEquivalent(a, b)
 	case reflect.Struct:
 		return _this.areStructsEquivalent(a, b)
 	case reflect.Uintptr:
-		return a.Pointer() == b.Pointer()
+		if a.Uint() != b.Uint() {
+			return _this.fail(ReasonValueDiffers, a, b)
+		}
+		return true
 	case reflect.UnsafePointer:
-		return a.UnsafeAddr() == b.UnsafeAddr()
+		if a.Pointer() != b.Pointer() {
+			return _this.fail(ReasonValueDiffers, a, b)
+		}
+		return true
 	case reflect.Chan:
-		return a.Type() == b.Type() && a.Type().Elem() == b.Type().Elem() && a.Type().ChanDir() == b.Type().ChanDir()
+		if a.Type() != b.Type() {
+			return _this.fail(ReasonTypeMismatch, a, b)
+		}
+		return true
 	case reflect.Func:
-		return a.Type() == b.Type()
+		if a.Type() != b.Type() {
+			return _this.fail(ReasonTypeMismatch, a, b)
+		}
+		return true
 	default:
-		return false
+		return _this.fail(ReasonTypeMismatch, a, b)
 	}
 }
 
-func drillDown(finder *duplicates.DuplicateFinder, v reflect.Value) (value reflect.Value, hasDuplicate bool) {
+// Drill down through pointers and interfaces to the first concrete value.
+// wasPointer will be true if any pointers were followed.
+func drillDown(v reflect.Value) (value reflect.Value, wasPointer bool) {
 	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
 		if v.Kind() == reflect.Ptr {
-			if hasDuplicate = finder.RegisterPointer(v); hasDuplicate {
-				return v, true
-			}
+			wasPointer = true
 		}
 		v = v.Elem()
 	}
-	return v, false
+	return v, wasPointer
 }
@@ -1,10 +1,14 @@
 package equivalence
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"math/big"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kstenerud/go-describe"
 )
@@ -309,6 +313,133 @@ func TestBigFloatEqual(t *testing.T) {
 	assertEquivalent(t, big.NewFloat(10000000.1234), big.NewFloat(10000000.1234))
 }
 
+func TestBigRatEqual(t *testing.T) {
+	assertEquivalent(t, new(big.Rat), 0)
+	assertEquivalent(t, big.NewRat(10, 2), 5)
+	assertEquivalent(t, 5, big.NewRat(10, 2))
+	assertEquivalent(t, big.NewRat(-70, 1), int8(-70))
+	assertEquivalent(t, big.NewRat(1, 2), 0.5)
+	assertEquivalent(t, float32(0.5), big.NewRat(1, 2))
+	assertEquivalent(t, big.NewRat(1, 2), big.NewFloat(0.5))
+	assertEquivalent(t, big.NewRat(7, 1), big.NewInt(7))
+	assertEquivalent(t, big.NewRat(1, 3), big.NewRat(2, 6))
+	assertEquivalent(t, *big.NewRat(1, 3), big.NewRat(1, 3))
+	assertEquivalent(t, new(big.Rat).SetUint64(math.MaxUint64), uint64(math.MaxUint64))
+
+	assertNotEquivalent(t, big.NewRat(1, 3), big.NewRat(1, 4))
+	assertNotEquivalent(t, big.NewRat(1, 3), 1.0/3)
+	assertNotEquivalent(t, big.NewRat(3, 2), 1)
+	assertNotEquivalent(t, big.NewRat(-1, 1), uint(1))
+	assertNotEquivalent(t, big.NewRat(1, 1), "1")
+	assertNotEquivalent(t, big.NewRat(1, 3), big.NewFloat(1.0/3))
+
+	assertDifferences(t, big.NewRat(1, 3), 1.0/3, ": lossy numeric conversion")
+	assertDifferences(t, big.NewRat(1, 2), 1.5, ": value differs")
+	assertDifferences(t, big.NewRat(3, 2), 1, ": lossy numeric conversion")
+
+	assertEquivalent(t, map[*big.Rat]string{big.NewRat(1, 2): "a", big.NewRat(4, 2): "b"},
+		map[interface{}]string{0.5: "a", 2: "b"})
+	assertNotEquivalent(t, map[*big.Rat]string{big.NewRat(1, 3): "a"}, map[float64]string{1.0 / 3: "a"})
+	assertNotEquivalent(t, map[interface{}]string{big.NewRat(1, 2): "a", 0.5: "b"}, map[float64]string{0.5: "a", 1: "b"})
+}
+
+type UnexportedBigNumbers struct {
+	total *big.Rat
+	count big.Int
+	mean  *big.Float
+}
+
+func TestUnexportedBigNumbers(t *testing.T) {
+	newValue := func(numerator int64) UnexportedBigNumbers {
+		return UnexportedBigNumbers{big.NewRat(numerator, 2), *big.NewInt(numerator), big.NewFloat(float64(numerator))}
+	}
+	// These can't be read, so they are compared as structs.
+	assertEquivalent(t, newValue(1), newValue(1))
+	assertEquivalent(t, UnexportedBigNumbers{total: big.NewRat(1, 2)}, UnexportedBigNumbers{total: big.NewRat(2, 4)})
+	assertNotEquivalent(t, newValue(1), newValue(3))
+	assertNotEquivalent(t, UnexportedBigNumbers{total: big.NewRat(1, 2)}, UnexportedBigNumbers{total: big.NewRat(1, 3)})
+	assertNotEquivalent(t, UnexportedBigNumbers{count: *big.NewInt(5)}, UnexportedBigNumbers{count: *big.NewInt(-5)})
+	assertDifferences(t, UnexportedBigNumbers{count: *big.NewInt(5)}, UnexportedBigNumbers{count: *big.NewInt(-5)},
+		".count.neg: value differs")
+}
+
+func TestComplexEqual(t *testing.T) {
+	assertEquivalent(t, complex(5, 0), 5)
+	assertEquivalent(t, complex(5, 0), int8(5))
+	assertEquivalent(t, complex(5, 0), 5.0)
+	assertEquivalent(t, complex(5, 0), big.NewFloat(5))
+	assertEquivalent(t, complex(5, 0), big.NewRat(5, 1))
+	assertEquivalent(t, uint(5), complex64(complex(5, 0)))
+	assertEquivalent(t, complex(0.5, 0), FixedPoint{50})
+	assertEquivalent(t, complex(5, math.Copysign(0, -1)), 5)
+
+	assertNotEquivalent(t, complex(5, 1), 5)
+	assertNotEquivalent(t, 5, complex(5, 1))
+	assertNotEquivalent(t, complex(5.5, 0), 5)
+	assertNotEquivalent(t, complex(5, 0), "5")
+	assertNotEquivalent(t, complex(math.NaN(), 0), 5)
+
+	assertDifferences(t, complex(5, 1), 5, ": lossy numeric conversion")
+	assertDifferences(t, complex(6, 0), 5, ": value differs")
+	assertDifferences(t, 5, complex(5.5, 0), ": lossy numeric conversion")
+	assertDifferences(t, complex(5, 0), "5", ": type mismatch")
+
+	assertEquivalent(t, map[complex128]string{complex(1, 0): "a", complex(1, 1): "b"}, map[interface{}]string{1: "a", complex64(complex(1, 1)): "b"})
+	assertNotEquivalent(t, map[interface{}]string{complex(1, 0): "a", 1: "b"}, map[int]string{1: "a", 2: "b"})
+}
+
+// A decimal type that can't produce its value
+type BrokenDecimal int
+
+func (_this BrokenDecimal) Rat() *big.Rat {
+	panic("broken")
+}
+
+func TestComparePanicInSubstitute(t *testing.T) {
+	// The panic happens while comparing the real part of the complex number,
+	// or the parsed number, in place of the original.
+	assertNotEquivalent(t, complex(5, 0), BrokenDecimal(7))
+	assertNotEquivalent(t, BrokenDecimal(7), complex(5, 0))
+	if Compare(complex(5, 0), BrokenDecimal(7)).IsEquivalent() {
+		t.Errorf("Expected a panic while comparing substitutes to be reported")
+	}
+	if CompareWith("7", BrokenDecimal(8), ParseNumericStrings(0)).IsEquivalent() {
+		t.Errorf("Expected a panic while comparing substitutes to be reported")
+	}
+	assertResultString(t, Compare([]interface{}{BrokenDecimal(7)}, []interface{}{7}),
+		"[0]: comparison panicked (broken): 7 (equivalence.BrokenDecimal) vs 7 (int)")
+}
+
+func TestExactNumericComparison(t *testing.T) {
+	assertEquivalent(t, uint64(0x1000000000000000), int64(0x1000000000000000))
+	assertEquivalent(t, int64(0x1000000000000000), uint64(0x1000000000000000))
+	assertNotEquivalent(t, int64(math.MinInt64), uint64(1<<63))
+	assertNotEquivalent(t, uint64(1<<63), int64(math.MinInt64))
+	assertNotEquivalent(t, -1, uint64(math.MaxUint64))
+	assertEquivalent(t, uint64(math.MaxUint64), new(big.Int).SetUint64(math.MaxUint64))
+	assertEquivalent(t, float64(1<<63), uint64(1<<63))
+	assertNotEquivalent(t, float64(1<<63), int64(math.MaxInt64))
+	assertEquivalent(t, float64(-(1 << 63)), int64(math.MinInt64))
+	assertNotEquivalent(t, float64(1<<64), uint64(math.MaxUint64))
+	assertEquivalent(t, float64(1<<70), new(big.Int).Lsh(big.NewInt(1), 70))
+
+	// big.Float values are compared by value, not by their precision.
+	highPrecision := new(big.Float).SetPrec(200).SetFloat64(0.1)
+	assertEquivalent(t, big.NewFloat(0.1), highPrecision)
+	assertEquivalent(t, 0.1, highPrecision)
+	parsed, _, _ := big.ParseFloat("0.1", 10, 200, big.ToNearestEven)
+	assertNotEquivalent(t, 0.1, parsed)
+	assertNotEquivalent(t, big.NewFloat(0.1), parsed)
+	nextAfter := new(big.Float).SetPrec(200).SetFloat64(1)
+	nextAfter.Add(nextAfter, new(big.Float).SetMantExp(big.NewFloat(1), -100))
+	assertNotEquivalent(t, 1, nextAfter)
+
+	assertEquivalent(t, math.Inf(1), new(big.Float).SetInf(false))
+	assertEquivalent(t, float32(math.Inf(-1)), new(big.Float).SetInf(true))
+	assertNotEquivalent(t, math.Inf(1), new(big.Float).SetInf(true))
+	assertNotEquivalent(t, math.Inf(1), new(big.Int).Lsh(big.NewInt(1), 2000))
+}
+
 func TestNotEqual(t *testing.T) {
 	assertNotEquivalent(t, -1, uint(1))
 	assertNotEquivalent(t, uint(1), -1)
@@ -373,6 +504,314 @@ func TestManyComparisons(t *testing.T) {
 	}
 }
 
+func assertDifferences(t *testing.T, a, b interface{}, expected ...string) {
+	result := Compare(a, b)
+	if result.IsEquivalent() != IsEquivalent(a, b) {
+		t.Errorf("Compare(%v, %v) disagrees with IsEquivalent", describe.D(a), describe.D(b))
+	}
+	actual := make(map[string]bool)
+	for _, difference := range result.Differences {
+		actual[fmt.Sprintf("%v: %v", difference.Path, difference.Reason)] = true
+	}
+	for _, e := range expected {
+		if !actual[e] {
+			t.Errorf("Expected difference [%v] comparing %v and %v, but got:\n%v", e, describe.D(a), describe.D(b), result)
+		}
+	}
+	if len(result.Differences) != len(expected) {
+		t.Errorf("Expected %v differences comparing %v and %v, but got:\n%v", len(expected), describe.D(a), describe.D(b), result)
+	}
+}
+
+func TestCompareEquivalent(t *testing.T) {
+	assertDifferences(t, nil, nil)
+	assertDifferences(t, 1, int8(1))
+	assertDifferences(t, []interface{}{1, "a"}, []interface{}{uint(1), "a"})
+}
+
+func TestCompareReasons(t *testing.T) {
+	assertDifferences(t, 1, "1", ": type mismatch")
+	assertDifferences(t, 1, 2, ": value differs")
+	assertDifferences(t, 1, 2.0, ": value differs")
+	assertDifferences(t, 1.5, 1, ": lossy numeric conversion")
+	assertDifferences(t, -1, uint(1), ": lossy numeric conversion")
+	assertDifferences(t, []int{1}, []int{1, 2}, ": length mismatch", "[1]: missing element")
+	assertDifferences(t, []int{1, 2, 3}, [2]int{5, 2}, ": length mismatch", "[0]: value differs", "[2]: missing element")
+	assertDifferences(t, 1, nil, ": value differs")
+	assertDifferences(t, map[string]int{"a": 1}, map[string]int{"b": 1}, `["a"]: missing key`, `["b"]: missing key`)
+}
+
+func TestComparePaths(t *testing.T) {
+	a := ComplexStruct{
+		Map:     map[interface{}]interface{}{"x": map[string]interface{}{"mystruct": MyStruct{10, "x"}}},
+		Struct:  MyStruct{1, "a"},
+		StructP: &MyStruct{100, "test"},
+	}
+	b := ComplexStruct{
+		Map:     map[interface{}]interface{}{"x": map[string]interface{}{"mystruct": MyStruct{11, "x"}}},
+		Struct:  MyStruct{1, "b"},
+		StructP: &MyStruct{101, "test"},
+	}
+	assertDifferences(t, a, b,
+		`.Map["x"]["mystruct"].IntVal: value differs`,
+		".Struct.StringVal: value differs",
+		".StructP.IntVal: value differs")
+
+	assertDifferences(t, []int{1, 2, 3, 4}, []int{1, 2, 3, 5}, "[3]: value differs")
+	assertDifferences(t, []int{0, 1, 2}, []int{1, 1, 3}, "[0]: value differs", "[2]: value differs")
+}
+
+func TestCompareMapLengthMismatch(t *testing.T) {
+	assertDifferences(t, map[string]int{"a": 1, "b": 2}, map[string]int{"a": 1, "c": 3, "d": 4},
+		": length mismatch",
+		`["b"]: missing key`,
+		`["c"]: missing key`,
+		`["d"]: missing key`)
+}
+
+func TestMissingKeyNotNil(t *testing.T) {
+	assertNotEquivalent(t, map[string]interface{}{"a": nil}, map[string]interface{}{"b": nil})
+	assertNotEquivalent(t, map[string]*int{"a": nil}, map[string]*int{"b": nil})
+	assertNotEquivalent(t, map[string]interface{}{"a": nil, "c": 1}, map[string]interface{}{"c": 1})
+	assertEquivalent(t, map[string]interface{}{"a": nil}, map[string]*int{"a": nil})
+	assertDifferences(t, map[string]interface{}{"a": nil}, map[string]interface{}{"b": nil}, `["a"]: missing key`, `["b"]: missing key`)
+
+	result := Compare(map[string]interface{}{"a": nil}, map[string]interface{}{"b": nil})
+	for _, difference := range result.Differences {
+		expected := SideB
+		if difference.Path == `["b"]` {
+			expected = SideA
+		}
+		if difference.MissingFrom != expected {
+			t.Errorf("Expected %v to be missing from %v", difference, expected)
+		}
+	}
+	result = Compare(map[string]interface{}{"a": nil}, map[string]interface{}{"a": 1})
+	if len(result.Differences) != 1 || result.Differences[0].MissingFrom != SideNeither {
+		t.Errorf("Expected a nil value that isn't missing but got %v", result)
+	}
+}
+
+type MyString string
+
+type Point struct {
+	X int
+	Y int
+}
+
+type WidePoint struct {
+	Y int64
+	X int64
+}
+
+type Color string
+
+func TestEmptyContainers(t *testing.T) {
+	assertEquivalent(t, []int{}, map[string]int{})
+	assertEquivalent(t, map[string]int{}, [0]string{})
+	assertEquivalent(t, []int(nil), map[string]int(nil))
+	assertNotEquivalent(t, []int{1}, map[string]int{})
+	assertNotEquivalent(t, map[int]int{0: 1}, []int{1})
+	assertDifferences(t, map[string]int{}, []int{1}, ": type mismatch")
+}
+
+func TestNamedStrings(t *testing.T) {
+	assertEquivalent(t, Color("red"), "red")
+	assertEquivalent(t, "red", Color("red"))
+	assertEquivalent(t, Color("red"), MyString("red"))
+	assertEquivalent(t, []Color{"red", "green"}, []string{"red", "green"})
+	assertEquivalent(t, map[string]Color{"a": "red"}, map[Color]interface{}{"a": "red"})
+	assertNotEquivalent(t, Color("red"), "blue")
+	assertNotEquivalent(t, Color("1"), 1)
+	assertNotEquivalent(t, "abc", []byte("abc"))
+	assertDifferences(t, Color("red"), "blue", ": value differs")
+}
+
+func TestMapKeys(t *testing.T) {
+	assertEquivalent(t, map[MyInt]string{1: "a", 2: "b"}, map[int64]string{1: "a", 2: "b"})
+	assertEquivalent(t, map[interface{}]int{big.NewInt(5): 1}, map[uint8]int{5: 1})
+	assertEquivalent(t, map[*big.Float]int{big.NewFloat(0.5): 1}, map[float32]int{0.5: 1})
+	assertNotEquivalent(t, map[*big.Int]int{big.NewInt(5): 1}, map[*big.Int]int{big.NewInt(6): 1})
+	assertEquivalent(t, map[MyString]int{"a": 1}, map[MyString]int{"a": 1})
+	assertEquivalent(t, map[MyString]int{"a": 1}, map[string]int{"a": 1})
+	assertEquivalent(t, map[Point]int{{1, 2}: 1}, map[WidePoint]int{{X: 1, Y: 2}: 1})
+	assertNotEquivalent(t, map[Point]int{{1, 2}: 1}, map[WidePoint]int{{X: 2, Y: 1}: 1})
+	assertEquivalent(t, map[[2]int8]int{{1, 2}: 1}, map[[2]uint64]int{{1, 2}: 1})
+	assertEquivalent(t, map[interface{}]int{[2]interface{}{int8(1), "x"}: 1}, map[[2]interface{}]int{{1.0, "x"}: 1})
+	assertNotEquivalent(t, map[float64]int{1.5: 1}, map[int]int{1: 1})
+	assertEquivalent(t, map[interface{}]int{nil: 1}, map[*int]int{nil: 1})
+	assertEquivalent(t, map[complex64]int{complex(1, 2): 1}, map[complex128]int{complex(1, 2): 1})
+
+	// Keys with their own equality rules are matched one at a time.
+	assertEquivalent(t, map[CaseInsensitive]int{"ABC": 1}, map[CaseInsensitive]int{"abc": 1})
+	assertEquivalent(t, map[Version]int{{1, 2, "a"}: 1}, map[interface{}]int{Version{1, 2, "b"}: 1})
+}
+
+func TestMapKeysWithOptions(t *testing.T) {
+	assertNotEquivalent(t, map[interface{}]int{[2]int{1, 2}: 1}, map[interface{}]int{[2]int{2, 1}: 1})
+	if !IsEquivalentWith(map[interface{}]int{[2]int{1, 2}: 1}, map[interface{}]int{[2]int{2, 1}: 1}, UnorderedSlices()) {
+		t.Errorf("Expected unordered array keys to be equivalent")
+	}
+	if IsEquivalentWith(map[int8]int{1: 1}, map[int64]int{1: 1}, RequireExactTypes(NumericKinds)) {
+		t.Errorf("Expected keys of different types to not be equivalent")
+	}
+	if !IsEquivalentWith(map[Point]int{{1, 2}: 1}, map[WidePoint]int{{Y: 1, X: 2}: 1}, MatchStructFieldsByPosition()) {
+		t.Errorf("Expected struct keys to match by position")
+	}
+
+	// Keys of the same type that are equivalent without being equal
+	if !IsEquivalentWith(map[[2]int]int{{1, 2}: 1}, map[[2]int]int{{2, 1}: 1}, UnorderedSlices()) {
+		t.Errorf("Expected unordered array keys of the same type to be equivalent")
+	}
+	assertEquivalent(t, map[TaggedStruct]int{{1, 2, "a"}: 1}, map[TaggedStruct]int{{3, 2, "a"}: 1})
+	negativeZero := math.Copysign(0, -1)
+	assertEquivalent(t, map[interface{}]int{0.0: 1}, map[interface{}]int{negativeZero: 1})
+	if IsEquivalentWith(map[interface{}]int{0.0: 1}, map[interface{}]int{negativeZero: 1}, DistinguishSignedZero()) {
+		t.Errorf("Expected zero keys of different signs to not be equivalent")
+	}
+	if IsEquivalentWith(map[float64]int{0.0: 1}, map[float64]int{negativeZero: 1}, DistinguishSignedZero()) {
+		t.Errorf("Expected zero keys of different signs to not be equivalent")
+	}
+	if !IsEquivalentWith(map[MyString]int{"ABC": 1}, map[MyString]int{"abc": 1},
+		WithComparer(reflect.TypeOf(MyString("")), func(a, b reflect.Value) bool {
+			return strings.EqualFold(a.String(), fmt.Sprint(b.Interface()))
+		})) {
+		t.Errorf("Expected comparers to apply to map keys")
+	}
+	alwaysEquivalent := func(a, b reflect.Value) bool { return true }
+	if !IsEquivalentWith(map[Pennies]int{1: 1}, map[int]int{2: 1}, WithComparer(reflect.TypeOf(Pennies(0)), alwaysEquivalent)) {
+		t.Errorf("Expected comparers to apply to numeric map keys")
+	}
+	if !IsEquivalentWith(map[int]int{2: 1}, map[Pennies]int{1: 1}, WithComparer(reflect.TypeOf(Pennies(0)), alwaysEquivalent)) {
+		t.Errorf("Expected comparers to apply to numeric map keys")
+	}
+	if IsEquivalent(map[Pennies]int{1: 1}, map[int]int{2: 1}) {
+		t.Errorf("Expected numeric map keys without a comparer to be compared as numbers")
+	}
+}
+
+type Pennies int
+
+func TestAmbiguousMapKeys(t *testing.T) {
+	ambiguous := map[interface{}]interface{}{int8(1): "a", int64(1): "b"}
+	assertNotEquivalent(t, ambiguous, map[interface{}]interface{}{1: "a", 2: "b"})
+	assertNotEquivalent(t, map[interface{}]interface{}{1: "a", 2: "b"}, ambiguous)
+	assertEquivalent(t, ambiguous, ambiguous)
+	assertEquivalent(t, ambiguous, map[interface{}]interface{}{int8(1): "b", int64(1): "a"})
+	assertEquivalent(t, ambiguous, map[interface{}]interface{}{int16(1): "b", uint(1): "a"})
+	assertNotEquivalent(t, ambiguous, map[interface{}]interface{}{int16(1): "b", uint(1): "c"})
+	assertNotEquivalent(t, ambiguous, map[interface{}]interface{}{int16(1): "a", uint(1): "b", 1.0: "c"})
+	assertNotEquivalent(t, map[interface{}]int{1.0: 1, uint(1): 1}, map[interface{}]int{1: 1, 2: 1})
+	assertNotEquivalent(t, map[CaseInsensitive]int{"a": 1, "A": 1}, map[CaseInsensitive]int{"a": 1, "b": 1})
+
+	assertDifferences(t, ambiguous, map[interface{}]interface{}{1: "a", 2: "b"},
+		`[1]: ambiguous key`,
+		`[2]: missing key`)
+	assertDifferences(t, map[interface{}]interface{}{1: "a", 2: "b", 3: "c"}, map[interface{}]interface{}{2: "b", 3: "c", int8(3): "c"},
+		`[3]: ambiguous key`,
+		`[1]: missing key`)
+
+	result := Compare(ambiguous, map[interface{}]interface{}{1: "a", 2: "b"})
+	for _, difference := range result.Differences {
+		if difference.Reason == ReasonAmbiguousKey {
+			if difference.B.IsValid() || !strings.HasPrefix(difference.Note, "equivalent to ") {
+				t.Errorf("Unexpected difference %v", difference)
+			}
+		}
+	}
+}
+
+type MapNode struct {
+	Map map[interface{}]interface{}
+}
+
+func TestAmbiguousMapKeysCyclic(t *testing.T) {
+	newCyclicMap := func() map[interface{}]interface{} {
+		m := map[interface{}]interface{}{}
+		node := &MapNode{m}
+		m[int8(1)] = node
+		m[int64(1)] = node
+		return m
+	}
+	assertEquivalent(t, newCyclicMap(), newCyclicMap())
+
+	different := newCyclicMap()
+	different[int8(1)] = &MapNode{map[interface{}]interface{}{"x": 1}}
+	assertNotEquivalent(t, newCyclicMap(), different)
+}
+
+func TestPointerMapKeys(t *testing.T) {
+	m := map[*Node]bool{{Value: 1}: true, {Value: 1}: true}
+	assertEquivalent(t, m, m)
+	assertEquivalent(t, m, map[*Node]bool{{Value: 1}: true, {Value: 1}: true})
+	assertNotEquivalent(t, m, map[*Node]bool{{Value: 1}: true, {Value: 2}: true})
+	assertNotEquivalent(t, m, map[*Node]bool{{Value: 1}: true, {Value: 1}: false})
+	assertNotEquivalent(t, m, map[*Node]bool{{Value: 1}: true})
+	assertDifferences(t, m, map[*Node]bool{{Value: 1}: true, {Value: 2}: true},
+		`[*equivalence.Node<Value=1 Next=nil>]: ambiguous key`,
+		`[*equivalence.Node<Value=2 Next=nil>]: missing key`)
+
+	sets := map[*Node]int{{Value: 1}: 1, {Value: 1}: 2}
+	assertEquivalent(t, sets, map[*Node]int{{Value: 1}: 2, {Value: 1}: 1})
+	assertNotEquivalent(t, sets, map[*Node]int{{Value: 1}: 1, {Value: 1}: 1})
+}
+
+func TestCompareDifferenceValues(t *testing.T) {
+	result := Compare(MyStruct{1, "a"}, MyStruct{1, "b"})
+	if len(result.Differences) != 1 {
+		t.Fatalf("Expected 1 difference but got %v", result)
+	}
+	difference := result.Differences[0]
+	if difference.A.String() != "a" || difference.B.String() != "b" {
+		t.Errorf("Unexpected values in %v", difference)
+	}
+	if difference.AType != reflect.TypeOf("") || difference.BType != reflect.TypeOf("") {
+		t.Errorf("Unexpected types in %v", difference)
+	}
+	expected := `.StringVal: value differs: "a" (string) vs "b" (string)`
+	if difference.String() != expected {
+		t.Errorf("Expected [%v] but got [%v]", expected, difference)
+	}
+}
+
+type Unexported struct {
+	a int
+	s []string
+	p *Unexported
+	n Node
+	i interface{}
+	c chan int
+}
+
+func TestDescribeUnexportedFields(t *testing.T) {
+	assertResultString(t, Compare(Unexported{a: 1}, Unexported{a: 2}),
+		".a: value differs: 1 (int) vs 2 (int)")
+	assertResultString(t, Compare(Unexported{s: []string{"x"}}, Unexported{s: []string{"y"}}),
+		`.s[0]: value differs: "x" (string) vs "y" (string)`)
+	assertResultString(t, Compare(Unexported{p: &Unexported{a: 1}}, Unexported{p: &Unexported{a: 1}, a: 1}),
+		".a: value differs: 0 (int) vs 1 (int)")
+	assertResultString(t, Compare(Unexported{n: Node{Value: 1}}, Unexported{}),
+		".n.Value: value differs: 1 (int) vs 0 (int)")
+	assertResultString(t, Compare(Unexported{s: []string{"x"}}, Unexported{}),
+		".s: length mismatch: string[\"x\"] ([]string) vs nil ([]string)\n.s[0]: missing element: \"x\" (string) vs nil (<nil>)")
+	assertResultString(t, Compare(Unexported{i: Unexported{a: 1}}, Unexported{i: 1}),
+		".i: type mismatch: equivalence.Unexported<a=1 s=nil p=nil n=equivalence.Node<Value=0 Next=nil> i=nil c=<nil>> (equivalence.Unexported) vs 1 (int)")
+}
+
+func assertResultString(t *testing.T, result Result, expected string) {
+	if result.String() != expected {
+		t.Errorf("Expected [%v] but got [%v]", expected, result)
+	}
+}
+
+func TestDescribeBigNumbers(t *testing.T) {
+	result := Compare(big.NewInt(1), []interface{}{2})
+	expected := "(root): type mismatch: big.Int<1> (big.Int) vs interface[@2] ([]interface {})"
+	if result.String() != expected {
+		t.Errorf("Expected [%v] but got [%v]", expected, result)
+	}
+}
+
 func DemonstrateEquivalence() {
 	a := ComplexStruct{
 		Map:     map[interface{}]interface{}{1: "a"},
@@ -391,3 +830,482 @@ func DemonstrateEquivalence() {
 func TestDemonstrateEquivalence(t *testing.T) {
 	DemonstrateEquivalence()
 }
+
+type ReorderedStruct struct {
+	StringVal string
+	IntVal    int
+}
+
+type SameShapeStruct struct {
+	Number int
+	Text   string
+}
+
+type ExtendedStruct struct {
+	IntVal    int
+	StringVal string
+	Extra     bool
+}
+
+func TestStructFieldsByName(t *testing.T) {
+	assertEquivalent(t, MyStruct{1, "a"}, ReorderedStruct{"a", 1})
+	assertEquivalent(t, ReorderedStruct{"a", 1}, &MyStruct{1, "a"})
+	assertNotEquivalent(t, MyStruct{1, "a"}, ReorderedStruct{"a", 2})
+	assertNotEquivalent(t, MyStruct{1, "a"}, SameShapeStruct{1, "a"})
+	assertNotEquivalent(t, MyStruct{1, "a"}, ExtendedStruct{1, "a", false})
+	assertNotEquivalent(t, ExtendedStruct{1, "a", false}, MyStruct{1, "a"})
+}
+
+func TestCompareStructFields(t *testing.T) {
+	assertDifferences(t, MyStruct{1, "a"}, ReorderedStruct{"b", 1}, ".StringVal: value differs")
+	assertDifferences(t, ExtendedStruct{1, "a", true}, MyStruct{1, "a"}, ".Extra: missing field")
+	assertDifferences(t, MyStruct{1, "a"}, ExtendedStruct{1, "a", true}, ".Extra: extra field")
+	assertDifferences(t, MyStruct{1, "a"}, SameShapeStruct{1, "a"},
+		".IntVal: missing field",
+		".StringVal: missing field",
+		".Number: extra field",
+		".Text: extra field")
+}
+
+type TaggedStruct struct {
+	ID        int `equivalence:"-"`
+	Number    int `equivalence:"name=IntVal"`
+	StringVal string
+}
+
+type IgnoredExtraStruct struct {
+	IntVal    int
+	StringVal string
+	Created   string `equivalence:"-"`
+}
+
+func TestStructTags(t *testing.T) {
+	assertEquivalent(t, TaggedStruct{1, 2, "a"}, TaggedStruct{100, 2, "a"})
+	assertNotEquivalent(t, TaggedStruct{1, 2, "a"}, TaggedStruct{1, 3, "a"})
+	assertEquivalent(t, TaggedStruct{1, 2, "a"}, MyStruct{2, "a"})
+	assertEquivalent(t, MyStruct{2, "a"}, TaggedStruct{1, 2, "a"})
+	assertNotEquivalent(t, MyStruct{3, "a"}, TaggedStruct{1, 2, "a"})
+	assertEquivalent(t, IgnoredExtraStruct{1, "a", "yesterday"}, MyStruct{1, "a"})
+	assertEquivalent(t, MyStruct{1, "a"}, IgnoredExtraStruct{1, "a", "today"})
+	assertDifferences(t, TaggedStruct{1, 2, "a"}, MyStruct{3, "a"}, ".Number: value differs")
+}
+
+type CaseInsensitive string
+
+func (_this CaseInsensitive) EquivalentTo(other interface{}) bool {
+	if s, ok := other.(string); ok {
+		return strings.EqualFold(string(_this), s)
+	}
+	if s, ok := other.(CaseInsensitive); ok {
+		return strings.EqualFold(string(_this), string(s))
+	}
+	return false
+}
+
+type Version struct {
+	Major, Minor int
+	Label        string
+}
+
+// Labels don't affect equality
+func (_this *Version) Equal(other *Version) bool {
+	return _this.Major == other.Major && _this.Minor == other.Minor
+}
+
+type VersionHolder struct {
+	Version Version
+}
+
+func TestEqualMethod(t *testing.T) {
+	now := time.Now()
+	assertEquivalent(t, now, now.Round(0))
+	assertEquivalent(t, now.In(time.UTC), now.In(time.FixedZone("X", 3600)))
+	assertNotEquivalent(t, now, now.Add(1))
+	assertEquivalent(t, []time.Time{now}, []interface{}{now.UTC()})
+
+	assertEquivalent(t, &Version{1, 2, "a"}, &Version{1, 2, "b"})
+	assertEquivalent(t, VersionHolder{Version{1, 2, "a"}}, &VersionHolder{Version{1, 2, "b"}})
+	assertNotEquivalent(t, &Version{1, 2, "a"}, &Version{1, 3, "a"})
+}
+
+func TestEquivalentInterface(t *testing.T) {
+	assertEquivalent(t, CaseInsensitive("Hello"), "hELLO")
+	assertEquivalent(t, "hELLO", CaseInsensitive("Hello"))
+	assertEquivalent(t, []interface{}{"hello"}, []CaseInsensitive{"HELLO"})
+	assertNotEquivalent(t, CaseInsensitive("Hello"), "Goodbye")
+	assertNotEquivalent(t, CaseInsensitive("1"), 1)
+}
+
+type Node struct {
+	Value int
+	Next  *Node
+}
+
+type Pair struct {
+	Left  *Node
+	Right *Node
+}
+
+func TestCyclicPointers(t *testing.T) {
+	a := &Node{Value: 1}
+	a.Next = a
+	b := &Node{Value: 1}
+	b.Next = b
+	assertEquivalent(t, a, b)
+
+	c := &Node{Value: 2}
+	c.Next = c
+	assertNotEquivalent(t, a, c)
+
+	// A ring of two 1s unrolls to the same infinite sequence as a ring of one.
+	d := &Node{Value: 1, Next: &Node{Value: 1}}
+	d.Next.Next = d
+	assertEquivalent(t, a, d)
+
+	e := &Node{Value: 1, Next: &Node{Value: 2}}
+	e.Next.Next = e
+	assertNotEquivalent(t, a, e)
+}
+
+func TestCyclicMaps(t *testing.T) {
+	a := map[string]interface{}{"x": 1}
+	a["self"] = a
+	b := map[string]interface{}{"x": 1}
+	b["self"] = b
+	assertEquivalent(t, a, b)
+
+	c := map[string]interface{}{"x": 2}
+	c["self"] = c
+	assertNotEquivalent(t, a, c)
+}
+
+func TestSharedReferences(t *testing.T) {
+	shared := &Node{Value: 1}
+	a := Pair{shared, shared}
+	assertEquivalent(t, a, Pair{&Node{Value: 1}, &Node{Value: 1}})
+	assertNotEquivalent(t, a, Pair{&Node{Value: 1}, &Node{Value: 2}})
+	assertNotEquivalent(t, Pair{&Node{Value: 1}, &Node{Value: 2}}, a)
+
+	sharedSlice := []int{1, 2}
+	assertNotEquivalent(t, [][]int{sharedSlice, sharedSlice}, [][]int{{1, 2}, {1, 3}})
+	assertNotEquivalent(t, [][]int{{1, 2}, {1, 3}}, [][]int{sharedSlice, sharedSlice})
+
+	sharedMap := map[string]int{"a": 1}
+	assertNotEquivalent(t, []interface{}{sharedMap, sharedMap}, []interface{}{map[string]int{"a": 1}, map[string]int{"a": 2}})
+}
+
+type Tree struct {
+	Value int
+	L     *Tree
+	R     *Tree
+}
+
+func TestSharedReferencesComparedOnce(t *testing.T) {
+	// Each node is shared by both L and R of the node above it, so comparing
+	// it again on every path would take 2^64 steps.
+	newChain := func(bottom int) *Tree {
+		node := &Tree{Value: bottom}
+		for i := 1; i < 64; i++ {
+			node = &Tree{Value: i, L: node, R: node}
+		}
+		return node
+	}
+	assertEquivalent(t, newChain(0), newChain(0))
+	assertNotEquivalent(t, newChain(0), newChain(1))
+	assertDifferences(t, newChain(0), newChain(1),
+		strings.Repeat(".L", 63)+".Value: value differs")
+}
+
+func TestSharedSliceData(t *testing.T) {
+	data := []int{1, 2, 3}
+	assertNotEquivalent(t, [][]int{data[:2], data[:3]}, [][]int{data[:2], data[:2]})
+}
+
+func TestCompareSharedReferences(t *testing.T) {
+	shared := &Node{Value: 1}
+	assertDifferences(t, Pair{shared, shared}, Pair{&Node{Value: 2}, &Node{Value: 3}},
+		".Left.Value: value differs",
+		".Right.Value: value differs")
+}
+
+// A fixed-point decimal with 2 decimal places
+type FixedPoint struct {
+	hundredths int64
+}
+
+func (_this FixedPoint) Rat() *big.Rat {
+	return big.NewRat(_this.hundredths, 100)
+}
+
+// A fixed-point decimal with 3 decimal places. Only values with a power of 2
+// denominator (such as 0.5 and 0.125) are exact when converted to big.Float.
+type Millis int64
+
+func (_this *Millis) BigFloat() *big.Float {
+	f := new(big.Float).SetPrec(200).SetInt64(int64(*_this))
+	return f.Quo(f, big.NewFloat(1000))
+}
+
+func TestDecimalTypes(t *testing.T) {
+	assertEquivalent(t, FixedPoint{150}, 1.5)
+	assertEquivalent(t, 1.5, FixedPoint{150})
+	assertEquivalent(t, FixedPoint{-300}, -3)
+	assertEquivalent(t, FixedPoint{300}, uint8(3))
+	assertEquivalent(t, FixedPoint{25}, big.NewRat(1, 4))
+	assertEquivalent(t, FixedPoint{100}, big.NewInt(1))
+	assertEquivalent(t, FixedPoint{50}, big.NewFloat(0.5))
+	assertEquivalent(t, FixedPoint{1250}, Millis(12500))
+	assertEquivalent(t, Millis(12500), &FixedPoint{1250})
+	assertEquivalent(t, Millis(125), 0.125)
+	assertEquivalent(t, Millis(-500), -0.5)
+	assertEquivalent(t, FixedPoint{0}, 0)
+	assertEquivalent(t, []interface{}{FixedPoint{1}, Millis(250)}, []interface{}{big.NewRat(1, 100), 0.25})
+	assertEquivalent(t, struct{ Price FixedPoint }{FixedPoint{199}}, struct{ Price *big.Rat }{big.NewRat(199, 100)})
+
+	assertNotEquivalent(t, FixedPoint{151}, 1.5)
+	assertNotEquivalent(t, FixedPoint{1}, 0.01)
+	assertNotEquivalent(t, FixedPoint{150}, 1)
+	assertNotEquivalent(t, Millis(1500), 1)
+	assertNotEquivalent(t, FixedPoint{100}, "1")
+	assertNotEquivalent(t, FixedPoint{100}, MyStruct{})
+
+	assertDifferences(t, FixedPoint{150}, 1, ": lossy numeric conversion")
+	assertDifferences(t, FixedPoint{150}, 2.5, ": value differs")
+
+	assertEquivalent(t, map[FixedPoint]string{{150}: "a"}, map[float64]string{1.5: "a"})
+	assertEquivalent(t, map[Millis]string{1500: "a"}, map[interface{}]string{FixedPoint{150}: "a"})
+	assertNotEquivalent(t, map[interface{}]string{FixedPoint{150}: "a", 1.5: "b"}, map[float64]string{1.5: "a", 2: "b"})
+}
+
+type Event struct {
+	Name string
+	at   time.Time
+}
+
+func TestTimes(t *testing.T) {
+	now := time.Now()
+	assertEquivalent(t, now, now.Round(0))
+	assertEquivalent(t, now, now.In(time.FixedZone("X", 3600)))
+	assertEquivalent(t, &now, now.UTC())
+	assertEquivalent(t, Event{"a", now}, Event{"a", now})
+	assertEquivalent(t, Event{"a", now}, Event{"a", now.Round(0)})
+	assertEquivalent(t, Event{"a", now}, Event{"a", now.Round(0).UTC()})
+	assertEquivalent(t, Event{"a", time.Time{}}, Event{"a", time.Time{}.In(time.FixedZone("X", 3600))})
+	assertNotEquivalent(t, Event{"a", now}, Event{"a", now.Add(1)})
+	assertNotEquivalent(t, now, now.Add(time.Nanosecond))
+	assertNotEquivalent(t, now, now.Unix())
+	assertNotEquivalent(t, now, "now")
+	assertEquivalent(t, map[time.Time]int{now: 1}, map[interface{}]int{now.UTC(): 1})
+
+	if !IsEquivalentWith(now, now.Round(0), IgnoreEqualityMethods()) {
+		t.Errorf("Expected times to be compared as instants even without equality methods")
+	}
+	assertDifferences(t, now, now.Add(1), ": value differs")
+
+	at := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	assertResultString(t, Compare(Event{"a", at}, Event{"a", at.Add(time.Second)}),
+		".at: value differs: time.Time<2020-01-02 03:04:05.000000006 +0000 UTC> (time.Time) vs time.Time<2020-01-02 03:04:06.000000006 +0000 UTC> (time.Time)")
+	if !IsEquivalentWith(Event{"a", now}, Event{"a", now.Round(0)}, RequireSameTimeLocation()) ||
+		IsEquivalentWith(Event{"a", now}, Event{"a", now.UTC()}, RequireSameTimeLocation()) {
+		t.Errorf("Expected the locations of unexported times to be compared")
+	}
+}
+
+func TestReadTimeFields(t *testing.T) {
+	if !canReadTimeFields {
+		t.Fatalf("Expected the internal fields of time.Time to be readable")
+	}
+	// This has a monotonic clock reading, which changes the encoding.
+	now := time.Now()
+	actual, location := readTimeFields(reflect.ValueOf(Event{"a", now}).Field(1))
+	if !actual.Equal(now) || location != "Local" {
+		t.Errorf("Expected %v in Local, but got %v in %v", now, actual, location)
+	}
+
+	// Without the self-check passing, unexported times are compared as structs.
+	canReadTimeFields = false
+	defer func() { canReadTimeFields = true }()
+	assertEquivalent(t, Event{"a", now}, Event{"a", now})
+	assertNotEquivalent(t, Event{"a", now}, Event{"a", now.Round(0)})
+	assertNotEquivalent(t, Event{"a", now}, Event{"a", now.Add(1)})
+}
+
+func TestDurations(t *testing.T) {
+	assertEquivalent(t, time.Second, 1000000000)
+	assertEquivalent(t, int64(1500), 1500*time.Nanosecond)
+	assertEquivalent(t, time.Millisecond, 1e6)
+	assertEquivalent(t, []time.Duration{time.Microsecond}, []uint16{1000})
+	assertNotEquivalent(t, time.Second, 1)
+	assertNotEquivalent(t, time.Second, "1s")
+}
+
+type Address struct {
+	City string `json:"city"`
+	Zip  int    `yaml:"zip_code"`
+}
+
+type Audit struct {
+	CreatedBy string `json:"created_by"`
+}
+
+type Person struct {
+	Audit
+	Name     string            `json:"name"`
+	Age      uint8             `json:"age,omitempty"`
+	Address  *Address          `json:"address"`
+	Tags     []string          `json:"tags"`
+	Extra    map[string]string `json:",omitempty"`
+	Password string            `json:"-"`
+	internal int
+}
+
+func TestStructAndMap(t *testing.T) {
+	person := Person{
+		Audit:    Audit{CreatedBy: "admin"},
+		Name:     "Alice",
+		Age:      30,
+		Address:  &Address{City: "Paris", Zip: 75001},
+		Tags:     []string{"a", "b"},
+		Password: "secret",
+		internal: 5,
+	}
+	asMap := map[string]interface{}{
+		"created_by": "admin",
+		"name":       "Alice",
+		"age":        30.0,
+		"address":    map[string]interface{}{"city": "Paris", "zip_code": int64(75001)},
+		"tags":       []interface{}{"a", "b"},
+	}
+	assertEquivalent(t, person, asMap)
+	assertEquivalent(t, asMap, &person)
+	assertEquivalent(t, []Person{person}, []interface{}{asMap})
+	assertEquivalent(t, map[string]interface{}{"p": person}, map[string]interface{}{"p": asMap})
+	assertEquivalent(t, Address{"Paris", 1}, map[interface{}]interface{}{"city": "Paris", "Zip": 1})
+	assertEquivalent(t, Address{"Paris", 1}, map[MyString]interface{}{"city": MyString("Paris"), "Zip": int8(1)})
+
+	encoded, err := json.Marshal(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	assertEquivalent(t, person, decoded)
+
+	// omitempty fields may be left out
+	assertEquivalent(t, Person{Name: "Bob"}, map[string]interface{}{"name": "Bob", "created_by": "", "address": nil, "tags": []interface{}{}})
+	assertNotEquivalent(t, Person{Name: "Bob", Age: 1}, map[string]interface{}{"name": "Bob", "created_by": "", "address": nil, "tags": []interface{}{}})
+
+	assertNotEquivalent(t, Address{"Paris", 1}, map[string]interface{}{"city": "Paris", "Zip": 1.5})
+	assertNotEquivalent(t, Address{"Paris", 1}, map[string]interface{}{"city": "Paris"})
+	assertNotEquivalent(t, Address{"Paris", 1}, map[string]interface{}{"city": "Paris", "Zip": 1, "country": "FR"})
+	assertNotEquivalent(t, Address{"Paris", 1}, map[int]interface{}{1: "Paris"})
+
+	assertDifferences(t, Address{"Paris", 1}, map[string]interface{}{"Zip": 2, "country": "FR"},
+		".City: missing field", ".Zip: value differs", `["country"]: missing key`)
+	assertDifferences(t, map[string]interface{}{"Zip": 2, "country": "FR"}, Address{"Paris", 1},
+		".City: extra field", ".Zip: value differs", `["country"]: missing key`)
+}
+
+type Cents struct {
+	Amount int64
+}
+
+func (_this Cents) Rat() *big.Rat {
+	return big.NewRat(_this.Amount, 100)
+}
+
+type opaque struct {
+	value int
+}
+
+func TestStructAndMapNeedsFields(t *testing.T) {
+	empty := map[string]interface{}{}
+	assertNotEquivalent(t, FixedPoint{150}, empty)
+	assertNotEquivalent(t, empty, FixedPoint{150})
+	assertNotEquivalent(t, opaque{1}, empty)
+	assertNotEquivalent(t, struct{}{}, empty)
+	assertNotEquivalent(t, Cents{150}, map[string]interface{}{"Amount": 150})
+	assertEquivalent(t, Cents{150}, 1.5)
+	assertNotEquivalent(t, Version{1, 2, "x"}, map[string]interface{}{"Major": 1, "Minor": 2, "Label": "x"})
+	assertEquivalentWith(t, Version{1, 2, "x"}, map[string]interface{}{"Major": 1, "Minor": 2, "Label": "x"}, IgnoreEqualityMethods())
+	assertNotEquivalent(t, time.Time{}, empty)
+	assertDifferences(t, FixedPoint{150}, empty, ": type mismatch")
+}
+
+type Inner struct {
+	A int
+}
+
+type Outer struct {
+	*Inner
+	B int
+}
+
+type TaggedInner struct {
+	A int `json:"a"`
+	C int
+}
+
+type Conflicting struct {
+	Inner
+	TaggedInner
+	D int `json:"C"`
+}
+
+type Ambiguous struct {
+	Inner
+	Other Inner `json:"-"`
+	Dup   struct {
+		A int
+	}
+	*Inner2
+}
+
+type Inner2 struct {
+	A int
+}
+
+func TestStructAndMapEmbedding(t *testing.T) {
+	outer := Outer{&Inner{1}, 2}
+	asMap := map[string]interface{}{"A": 1, "B": 2}
+	assertEquivalent(t, outer, asMap)
+	assertEquivalent(t, asMap, outer)
+	assertNotEquivalent(t, outer, map[string]interface{}{"A": 2, "B": 2})
+	assertDifferences(t, Outer{nil, 2}, asMap, `["A"]: missing key`)
+	assertEquivalent(t, Outer{nil, 2}, map[string]interface{}{"B": 2})
+
+	encoded, err := json.Marshal(outer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	assertEquivalent(t, outer, decoded)
+
+	// Inner.A and TaggedInner's "a" don't clash, but TaggedInner.C is hidden
+	// by the shallower D.
+	conflicting := Conflicting{Inner{1}, TaggedInner{2, 3}, 4}
+	assertEquivalent(t, conflicting, map[string]interface{}{"A": 1, "a": 2, "C": 4})
+
+	// Inner.A and Inner2.A are at the same depth and neither is tagged, so
+	// encoding/json drops both.
+	ambiguous := Ambiguous{Inner: Inner{1}, Inner2: &Inner2{2}}
+	ambiguous.Dup.A = 3
+	assertEquivalent(t, ambiguous, map[string]interface{}{"Dup": map[string]interface{}{"A": 3}})
+
+	for _, value := range []interface{}{conflicting, ambiguous} {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		assertEquivalent(t, value, decoded)
+	}
+}
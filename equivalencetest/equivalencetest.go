@@ -0,0 +1,109 @@
+// Package equivalencetest provides test assertions built on the equivalence
+// package.
+//
+// The Assert functions report a failure and let the test continue. The Require
+// functions report a failure and stop the test immediately.
+package equivalencetest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/kstenerud/go-describe"
+	"github.com/kstenerud/go-equivalence"
+)
+
+// AssertEquivalent fails the test (but continues running it) if expected and
+// actual are not equivalent.
+//
+// msgAndArgs is an optional message to include in the failure, either as a
+// single value or as a format string followed by its arguments.
+//
+// Returns true if the objects are equivalent.
+func AssertEquivalent(t testing.TB, expected, actual interface{}, msgAndArgs ...interface{}) bool {
+	t.Helper()
+	if message, ok := checkEquivalent(expected, actual, msgAndArgs); !ok {
+		t.Error(message)
+		return false
+	}
+	return true
+}
+
+// AssertNotEquivalent fails the test (but continues running it) if expected
+// and actual are equivalent.
+//
+// msgAndArgs is an optional message to include in the failure, either as a
+// single value or as a format string followed by its arguments.
+//
+// Returns true if the objects are not equivalent.
+func AssertNotEquivalent(t testing.TB, expected, actual interface{}, msgAndArgs ...interface{}) bool {
+	t.Helper()
+	if message, ok := checkNotEquivalent(expected, actual, msgAndArgs); !ok {
+		t.Error(message)
+		return false
+	}
+	return true
+}
+
+// RequireEquivalent fails and stops the test if expected and actual are not
+// equivalent.
+//
+// msgAndArgs is an optional message to include in the failure, either as a
+// single value or as a format string followed by its arguments.
+func RequireEquivalent(t testing.TB, expected, actual interface{}, msgAndArgs ...interface{}) {
+	t.Helper()
+	if message, ok := checkEquivalent(expected, actual, msgAndArgs); !ok {
+		t.Fatal(message)
+	}
+}
+
+// RequireNotEquivalent fails and stops the test if expected and actual are
+// equivalent.
+//
+// msgAndArgs is an optional message to include in the failure, either as a
+// single value or as a format string followed by its arguments.
+func RequireNotEquivalent(t testing.TB, expected, actual interface{}, msgAndArgs ...interface{}) {
+	t.Helper()
+	if message, ok := checkNotEquivalent(expected, actual, msgAndArgs); !ok {
+		t.Fatal(message)
+	}
+}
+
+func checkEquivalent(expected, actual interface{}, msgAndArgs []interface{}) (message string, ok bool) {
+	result := equivalence.Compare(expected, actual)
+	if result.IsEquivalent() {
+		return "", true
+	}
+	return fmt.Sprintf("%vExpected %v (%v) and %v (%v) to be equivalent\nDiff (-expected +actual):\n%v",
+		formatMessage(msgAndArgs),
+		describe.D(expected), reflect.TypeOf(expected),
+		describe.D(actual), reflect.TypeOf(actual),
+		result.Diff(false)), false
+}
+
+func checkNotEquivalent(expected, actual interface{}, msgAndArgs []interface{}) (message string, ok bool) {
+	if !equivalence.IsEquivalent(expected, actual) {
+		return "", true
+	}
+	return fmt.Sprintf("%vExpected %v (%v) and %v (%v) to not be equivalent",
+		formatMessage(msgAndArgs),
+		describe.D(expected), reflect.TypeOf(expected),
+		describe.D(actual), reflect.TypeOf(actual)), false
+}
+
+// Format the optional user message, ending it with a newline so that it can be
+// placed in front of the failure details.
+func formatMessage(msgAndArgs []interface{}) string {
+	switch len(msgAndArgs) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("%v\n", msgAndArgs[0])
+	default:
+		if format, ok := msgAndArgs[0].(string); ok {
+			return fmt.Sprintf(format, msgAndArgs[1:]...) + "\n"
+		}
+		return fmt.Sprintln(msgAndArgs...)
+	}
+}
@@ -0,0 +1,94 @@
+package equivalencetest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Records failures instead of reporting them, so that failing assertions can
+// be tested.
+type recordingT struct {
+	testing.TB
+	errors  []string
+	isFatal bool
+}
+
+func (_this *recordingT) Helper() {}
+
+func (_this *recordingT) Error(args ...interface{}) {
+	_this.errors = append(_this.errors, fmt.Sprint(args...))
+}
+
+func (_this *recordingT) Fatal(args ...interface{}) {
+	_this.errors = append(_this.errors, fmt.Sprint(args...))
+	_this.isFatal = true
+}
+
+func assertFailedWith(t *testing.T, rt *recordingT, isFatal bool, substrings ...string) {
+	t.Helper()
+	if len(rt.errors) != 1 {
+		t.Fatalf("Expected 1 failure but got %v: %v", len(rt.errors), rt.errors)
+	}
+	if rt.isFatal != isFatal {
+		t.Errorf("Expected isFatal %v but got %v", isFatal, rt.isFatal)
+	}
+	for _, substring := range substrings {
+		if !strings.Contains(rt.errors[0], substring) {
+			t.Errorf("Expected failure message to contain %q, but got:\n%v", substring, rt.errors[0])
+		}
+	}
+}
+
+func TestPassing(t *testing.T) {
+	rt := &recordingT{}
+	if !AssertEquivalent(rt, 1, int8(1)) {
+		t.Errorf("Expected AssertEquivalent to pass")
+	}
+	if !AssertNotEquivalent(rt, 1, 2) {
+		t.Errorf("Expected AssertNotEquivalent to pass")
+	}
+	RequireEquivalent(rt, []interface{}{1, "a"}, []interface{}{uint(1), "a"})
+	RequireNotEquivalent(rt, "a", "b")
+	if len(rt.errors) != 0 {
+		t.Errorf("Expected no failures but got %v", rt.errors)
+	}
+}
+
+func TestAssertEquivalentFails(t *testing.T) {
+	rt := &recordingT{}
+	if AssertEquivalent(rt, []int{1, 2}, []int{1, 3}) {
+		t.Errorf("Expected AssertEquivalent to fail")
+	}
+	assertFailedWith(t, rt, false,
+		"Expected int[1 2] ([]int) and int[1 3] ([]int) to be equivalent",
+		"-  [1] = 2\n+  [1] = 3")
+}
+
+func TestAssertNotEquivalentFails(t *testing.T) {
+	rt := &recordingT{}
+	if AssertNotEquivalent(rt, 1, 1.0) {
+		t.Errorf("Expected AssertNotEquivalent to fail")
+	}
+	assertFailedWith(t, rt, false, "Expected 1 (int) and 1 (float64) to not be equivalent")
+}
+
+func TestRequireFails(t *testing.T) {
+	rt := &recordingT{}
+	RequireEquivalent(rt, 1, 2)
+	assertFailedWith(t, rt, true, "to be equivalent")
+
+	rt = &recordingT{}
+	RequireNotEquivalent(rt, 1, 1)
+	assertFailedWith(t, rt, true, "to not be equivalent")
+}
+
+func TestMessage(t *testing.T) {
+	rt := &recordingT{}
+	AssertEquivalent(rt, 1, 2, "simple message")
+	assertFailedWith(t, rt, false, "simple message\nExpected")
+
+	rt = &recordingT{}
+	AssertEquivalent(rt, 1, 2, "case %v of %v", 5, "tests")
+	assertFailedWith(t, rt, false, "case 5 of tests\nExpected")
+}
@@ -0,0 +1,98 @@
+package equivalence
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+)
+
+// NaNPolicy decides when NaN values are equivalent to each other.
+type NaNPolicy int
+
+const (
+	// Any NaN is equivalent to any other NaN, regardless of payload. This is
+	// the default.
+	NaNsAreEquivalent NaNPolicy = iota
+	// NaNs are only equivalent if their bits (including the payload) are
+	// identical. float32 NaNs are compared after conversion to float64.
+	NaNsMatchPayload
+	// NaN is never equivalent to anything, including itself (IEEE 754 rules).
+	NaNsNeverEquivalent
+)
+
+func isNaNValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return math.IsNaN(v.Float())
+	}
+	return false
+}
+
+// Returns whether numeric value v is zero, and if so, whether it's negative
+// zero.
+func getZeroSign(v reflect.Value) (isZero bool, isNegative bool) {
+	switch getNumericClass(v) {
+	case numericClassInt:
+		return v.Int() == 0, false
+	case numericClassUint:
+		return v.Uint() == 0, false
+	case numericClassFloat:
+		f := v.Float()
+		return f == 0, math.Signbit(f)
+	case numericClassBigInt:
+		bi := v.Interface().(big.Int)
+		return bi.Sign() == 0, false
+	case numericClassBigFloat:
+		bf := v.Interface().(big.Float)
+		return bf.Sign() == 0, bf.Signbit()
+	case numericClassBigRat:
+		br := v.Interface().(big.Rat)
+		return br.Sign() == 0, false
+	case numericClassDecimal:
+		r, f := getDecimalValue(v)
+		if f != nil {
+			return f.Sign() == 0, f.Signbit()
+		}
+		return r.Sign() == 0, false
+	}
+	return false, false
+}
+
+func (_this *comparator) areNaNsEquivalent(a, b float64) bool {
+	switch _this.options.nanPolicy {
+	case NaNsMatchPayload:
+		return math.Float64bits(a) == math.Float64bits(b)
+	case NaNsNeverEquivalent:
+		return false
+	default:
+		return true
+	}
+}
+
+// Compare numeric values that have special rules: NaN and zero (which can be
+// signed). ok will be false if the values aren't both zero and neither is NaN.
+func (_this *comparator) compareSpecialNumbers(a, b reflect.Value) (isEquivalent bool, ok bool) {
+	aIsNaN := isNaNValue(a)
+	bIsNaN := isNaNValue(b)
+	if aIsNaN || bIsNaN {
+		if aIsNaN && bIsNaN {
+			return _this.areNaNsEquivalent(a.Float(), b.Float()), true
+		}
+		return false, true
+	}
+
+	aIsZero, aIsNegative := getZeroSign(a)
+	bIsZero, bIsNegative := getZeroSign(b)
+	if aIsZero && bIsZero {
+		return aIsNegative == bIsNegative || !_this.options.distinguishSignedZero, true
+	}
+	return false, false
+}
+
+// Compare two floats using the configured NaN and signed zero rules.
+func (_this *comparator) areFloatsEquivalent(a, b float64) bool {
+	if isEquivalent, ok := _this.compareSpecialNumbers(reflect.ValueOf(a), reflect.ValueOf(b)); ok {
+		return isEquivalent
+	}
+	return a == b
+}
@@ -2,7 +2,4 @@ module github.com/kstenerud/go-equivalence
 
 go 1.10
 
-require (
-	github.com/kstenerud/go-describe v1.2.13
-	github.com/kstenerud/go-duplicates v1.1.1
-)
+require github.com/kstenerud/go-describe v1.2.13
@@ -0,0 +1,622 @@
+package equivalence
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Map keys are matched by converting each key into a canonical string that is
+// the same for all keys that are equivalent to each other, so that the keys of
+// one map can be looked up in an index of the other map's keys.
+//
+// Keys that can't be converted (such as those compared using a custom comparer
+// or an equality method) are matched by comparing them one at a time.
+//
+// Building these indexes is expensive, so maps that share a plain key type
+// (see plainKeyType) look up their keys directly instead, as do maps with
+// interface keys whose keys are identical.
+//
+// Float tolerances don't apply to map keys, since values within a tolerance of
+// each other can't share a canonical form.
+
+// Keys nested deeper than this (which can only happen through pointers) are
+// matched one at a time.
+const maxCanonicalKeyDepth = 32
+
+// Unique IDs for types, for canonical keys that must include their type.
+var typeIDs sync.Map
+var typeIDsMutex sync.Mutex
+var nextTypeID int
+
+func getTypeID(t reflect.Type) int {
+	if id, ok := typeIDs.Load(t); ok {
+		return id.(int)
+	}
+	typeIDsMutex.Lock()
+	defer typeIDsMutex.Unlock()
+	if id, ok := typeIDs.Load(t); ok {
+		return id.(int)
+	}
+	nextTypeID++
+	typeIDs.Store(t, nextTypeID)
+	return nextTypeID
+}
+
+type canonicalKeyBuilder struct {
+	comparator *comparator
+	buff       bytes.Buffer
+}
+
+func (_this *canonicalKeyBuilder) writeTypeID(t reflect.Type) {
+	_this.buff.WriteByte('#')
+	_this.buff.WriteString(strconv.Itoa(getTypeID(t)))
+}
+
+// Returns true if v's type has its own idea of equivalence that can't be
+// captured in a canonical key.
+func (_this *comparator) hasCustomEquivalence(v reflect.Value) bool {
+	t := v.Type()
+	if _this.hasCustomComparer(t) {
+		return true
+	}
+	if _this.options.ignoreEqualityMethods || (t.Name() == "" && t.Kind() != reflect.Struct) {
+		return false
+	}
+	methods := getEqualityMethods(t)
+	return methods.value.hasMethods() || methods.pointer.hasMethods()
+}
+
+func (_this *canonicalKeyBuilder) writeNumber(v reflect.Value) bool {
+	if isZero, isNegative := getZeroSign(v); isZero {
+		if isNegative && _this.comparator.options.distinguishSignedZero {
+			_this.buff.WriteString("-0")
+		} else {
+			_this.buff.WriteString("0")
+		}
+		return true
+	}
+
+	switch getNumericClass(v) {
+	case numericClassInt:
+		_this.buff.WriteString(strconv.FormatInt(v.Int(), 10))
+		return true
+	case numericClassUint:
+		_this.buff.WriteString(strconv.FormatUint(v.Uint(), 10))
+		return true
+	case numericClassFloat:
+		f := v.Float()
+		if math.IsNaN(f) {
+			// NaN equivalence depends on the NaN policy.
+			return false
+		}
+		if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			_this.buff.WriteString(strconv.FormatInt(int64(f), 10))
+			return true
+		}
+	}
+
+	switch getInfinitySign(v) {
+	case 1:
+		_this.buff.WriteString("+Inf")
+		return true
+	case -1:
+		_this.buff.WriteString("-Inf")
+		return true
+	}
+	r := numericToRat(v)
+	if r.IsInt() {
+		_this.buff.WriteString(r.Num().String())
+	} else {
+		_this.buff.WriteString(r.String())
+	}
+	return true
+}
+
+// Write the canonical keys of an array's elements in sorted order, for arrays
+// compared as multisets.
+func (_this *canonicalKeyBuilder) writeUnorderedArray(v reflect.Value, depth int) bool {
+	elements := make([]string, v.Len())
+	for i := range elements {
+		element, ok := _this.comparator.getCanonicalKeyAtDepth(v.Index(i), depth+1)
+		if !ok {
+			return false
+		}
+		elements[i] = element
+	}
+	sort.Strings(elements)
+	for _, element := range elements {
+		_this.buff.WriteString(strconv.Quote(element))
+		_this.buff.WriteByte(',')
+	}
+	return true
+}
+
+func (_this *canonicalKeyBuilder) writeStruct(v reflect.Value, depth int) bool {
+	fields := getStructFields(v.Type())
+	if _this.comparator.options.matchStructFieldsByPosition {
+		for _, field := range fields {
+			if field.isIgnored {
+				// Whether the field is compared depends on the other struct.
+				return false
+			}
+		}
+		for _, field := range fields {
+			if !_this.write(v.Field(field.index), depth+1, field.isUnordered) {
+				return false
+			}
+			_this.buff.WriteByte(',')
+		}
+		return true
+	}
+
+	sortedFields := make([]structField, 0, len(fields))
+	for _, field := range fields {
+		if !field.isIgnored {
+			sortedFields = append(sortedFields, field)
+		}
+	}
+	sort.Slice(sortedFields, func(i, j int) bool {
+		return sortedFields[i].matchName < sortedFields[j].matchName
+	})
+	for _, field := range sortedFields {
+		_this.buff.WriteString(strconv.Quote(field.matchName))
+		_this.buff.WriteByte('=')
+		if !_this.write(v.Field(field.index), depth+1, field.isUnordered) {
+			return false
+		}
+		_this.buff.WriteByte(',')
+	}
+	return true
+}
+
+func (_this *canonicalKeyBuilder) write(v reflect.Value, depth int, isUnordered bool) bool {
+	if depth > maxCanonicalKeyDepth {
+		return false
+	}
+	v, _ = drillDown(v)
+	if !v.IsValid() {
+		_this.buff.WriteByte('z')
+		return true
+	}
+	if _this.comparator.hasCustomEquivalence(v) {
+		return false
+	}
+	if _this.comparator.options.exactTypeKinds&getKindGroup(v) != 0 {
+		_this.writeTypeID(v.Type())
+	}
+
+	if getNumericClass(v) != numericClassNone {
+		_this.buff.WriteByte('n')
+		return _this.writeNumber(v)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			_this.buff.WriteString("b1")
+		} else {
+			_this.buff.WriteString("b0")
+		}
+		return true
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		if isZero, isNegative := getZeroSign(reflect.ValueOf(imag(c))); isZero && !(isNegative && _this.comparator.options.distinguishSignedZero) {
+			// Equivalent to the real part on its own
+			_this.buff.WriteByte('n')
+			return _this.writeNumber(reflect.ValueOf(real(c)))
+		}
+		_this.buff.WriteString("c(")
+		if !_this.writeNumber(reflect.ValueOf(real(c))) {
+			return false
+		}
+		_this.buff.WriteByte(',')
+		if !_this.writeNumber(reflect.ValueOf(imag(c))) {
+			return false
+		}
+		_this.buff.WriteByte(')')
+		return true
+	case reflect.String:
+		if _this.comparator.options.parseNumericStrings {
+			if _, ok := parseNumericString(v.String(), _this.comparator.options.numericStringFlags); ok {
+				// Equivalent to both the number and the exact same string.
+				return false
+			}
+		}
+		_this.buff.WriteByte('s')
+		_this.buff.WriteString(strconv.Quote(v.String()))
+		return true
+	case reflect.Array:
+		if _this.comparator.options.compareBytesAndRunesAsText && getTextKind(v) != textKindNone {
+			// Equivalent to both strings and arrays of other numeric types.
+			return false
+		}
+		_this.buff.WriteByte('[')
+		if isUnordered || _this.comparator.options.unorderedSlices {
+			if !_this.writeUnorderedArray(v, depth) {
+				return false
+			}
+		} else {
+			for i := 0; i < v.Len(); i++ {
+				if !_this.write(v.Index(i), depth+1, false) {
+					return false
+				}
+				_this.buff.WriteByte(',')
+			}
+		}
+		_this.buff.WriteByte(']')
+		return true
+	case reflect.Struct:
+		_this.buff.WriteByte('{')
+		if !_this.writeStruct(v, depth) {
+			return false
+		}
+		_this.buff.WriteByte('}')
+		return true
+	case reflect.Uintptr:
+		_this.buff.WriteByte('p')
+		_this.buff.WriteString(strconv.FormatUint(v.Uint(), 10))
+		return true
+	case reflect.UnsafePointer:
+		_this.buff.WriteByte('P')
+		_this.buff.WriteString(strconv.FormatUint(uint64(v.Pointer()), 10))
+		return true
+	case reflect.Chan:
+		// Channels are equivalent if they have the same type.
+		_this.buff.WriteByte('h')
+		_this.writeTypeID(v.Type())
+		return true
+	}
+	return false
+}
+
+// Get a string that is the same for all map keys that are equivalent to v
+// under this comparator's options. ok will be false if v has no canonical
+// form.
+func (_this *comparator) getCanonicalKey(v reflect.Value) (key string, ok bool) {
+	return _this.getCanonicalKeyAtDepth(v, 0)
+}
+
+func (_this *comparator) getCanonicalKeyAtDepth(v reflect.Value, depth int) (key string, ok bool) {
+	builder := canonicalKeyBuilder{comparator: _this}
+	if !builder.write(v, depth, false) {
+		return "", false
+	}
+	return builder.buff.String(), true
+}
+
+// Keys of a plain type are only equivalent to each other if they're equal, so
+// maps that share a plain key type can look up each other's keys directly,
+// and can't have several keys that are equivalent to each other.
+type plainKeyType struct {
+	isPlain bool
+	// If true, the type contains arrays, which might be compared as multisets.
+	hasArrays bool
+	// If true, the type contains floats, which are only plain if they are
+	// neither zero nor NaN (see isPlainKey).
+	hasFloats bool
+	// The key type and the types within it, which mustn't have comparers.
+	types []reflect.Type
+}
+
+// Cache of reflect.Type -> *plainKeyType
+var plainKeyTypes sync.Map
+
+func getPlainKeyType(t reflect.Type) *plainKeyType {
+	if info, ok := plainKeyTypes.Load(t); ok {
+		return info.(*plainKeyType)
+	}
+	info := &plainKeyType{isPlain: true}
+	info.add(t)
+	plainKeyTypes.Store(t, info)
+	return info
+}
+
+func (_this *plainKeyType) add(t reflect.Type) {
+	if !_this.isPlain {
+		return
+	}
+	_this.types = append(_this.types, t)
+	if t.Name() != "" || t.Kind() == reflect.Struct {
+		methods := getEqualityMethods(t)
+		decimalMethods := getDecimalMethods(t)
+		if methods.value.hasMethods() || methods.pointer.hasMethods() || decimalMethods.isDecimal() {
+			_this.isPlain = false
+			return
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Uintptr,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	case reflect.Float32, reflect.Float64:
+		_this.hasFloats = true
+	case reflect.Array:
+		_this.hasArrays = true
+		_this.add(t.Elem())
+	case reflect.Struct:
+		for _, field := range getStructFields(t) {
+			if field.isIgnored || field.isUnordered {
+				_this.isPlain = false
+				return
+			}
+			_this.add(t.Field(field.index).Type)
+		}
+	default:
+		// Pointers, interfaces, and so on can be equivalent without being
+		// equal.
+		_this.isPlain = false
+	}
+}
+
+// Returns true if keys of type t are plain (see plainKeyType) under this
+// comparator's options. Floats are only allowed if allowFloats is true.
+func (_this *comparator) isPlainKeyType(t reflect.Type, allowFloats bool) bool {
+	info := getPlainKeyType(t)
+	if !info.isPlain || (info.hasFloats && !allowFloats) || (info.hasArrays && _this.options.unorderedSlices) {
+		return false
+	}
+	for _, t := range info.types {
+		if _this.lookupComparerForType(t) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if the map key k is plain (see plainKeyType). A float key is
+// plain unless it's zero (which is equal to negative zero) or NaN (which is
+// equivalent to other NaNs).
+func (_this *comparator) isPlainKey(k reflect.Value) bool {
+	k = unwrapInterface(k)
+	if !k.IsValid() {
+		return false
+	}
+	allowFloats := false
+	switch k.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f := k.Float()
+		allowFloats = f != 0 && !math.IsNaN(f)
+	}
+	return _this.isPlainKeyType(k.Type(), allowFloats)
+}
+
+// Returns true if maps a and b share a plain key type (see plainKeyType).
+func (_this *comparator) haveSamePlainKeyType(a, b reflect.Value) bool {
+	t := a.Type().Key()
+	return t == b.Type().Key() && _this.isPlainKeyType(t, false)
+}
+
+// Returns true if every key in a is plain, and has an identical key in b with
+// an equivalent value. Maps with interface keys usually match this way, which
+// is much cheaper than building key indexes.
+//
+// If several keys in a are equivalent to each other, b has the same keys, and
+// pairing them up identically is as good as any other pairing (see
+// canPairKeyGroups). A false result means nothing, since some other pairing
+// might still work.
+func (_this *comparator) areMapsEquivalentByIdenticalKeys(a, b reflect.Value) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+	for _, k := range a.MapKeys() {
+		if !_this.isPlainKey(k) {
+			return false
+		}
+		bv := b.MapIndex(k)
+		if !bv.IsValid() {
+			return false
+		}
+		_this.pushMapKey(a, b, k)
+		isEquivalent := _this.areObjectsEquivalent(a.MapIndex(k), bv)
+		_this.popPath()
+		if !isEquivalent {
+			return false
+		}
+	}
+	return true
+}
+
+// An index of a map's keys by their canonical form.
+type mapKeyIndex struct {
+	// All keys in the map. Keys are referred to by their position here.
+	all  []reflect.Value
+	keys map[string]int
+	// Keys that have no canonical form
+	others []int
+	// Groups of keys in this map that are equivalent to each other
+	ambiguousGroups [][]int
+	// The ambiguous group that each key belongs to, or -1
+	groupOf []int
+}
+
+func (_this *comparator) newMapKeyIndex(m reflect.Value) *mapKeyIndex {
+	index := &mapKeyIndex{
+		all:  _this.getMapKeys(m),
+		keys: make(map[string]int, m.Len()),
+	}
+	index.groupOf = make([]int, len(index.all))
+	for i := range index.groupOf {
+		index.groupOf[i] = -1
+	}
+
+	for i, k := range index.all {
+		if canonical, ok := _this.getCanonicalKey(k); ok {
+			if existing, exists := index.keys[canonical]; exists {
+				index.markAmbiguous(existing, i)
+			} else {
+				index.keys[canonical] = i
+			}
+		} else {
+			index.others = append(index.others, i)
+		}
+	}
+
+	// Keys without a canonical form have to be checked against every other
+	// key. Pairs of such keys only need to be checked once.
+	isChecked := make(map[int]bool, len(index.others))
+	for _, i := range index.others {
+		isChecked[i] = true
+		for j, k := range index.all {
+			if isChecked[j] {
+				continue
+			}
+			if _this.areElementsEquivalent(index.all[i], k) {
+				index.markAmbiguous(i, j)
+			}
+		}
+	}
+	return index
+}
+
+// Record that the keys at positions i and j are equivalent.
+func (_this *mapKeyIndex) markAmbiguous(i, j int) {
+	iGroup := _this.groupOf[i]
+	jGroup := _this.groupOf[j]
+	switch {
+	case iGroup < 0 && jGroup < 0:
+		_this.groupOf[i] = len(_this.ambiguousGroups)
+		_this.groupOf[j] = len(_this.ambiguousGroups)
+		_this.ambiguousGroups = append(_this.ambiguousGroups, []int{i, j})
+	case jGroup < 0:
+		_this.groupOf[j] = iGroup
+		_this.ambiguousGroups[iGroup] = append(_this.ambiguousGroups[iGroup], j)
+	case iGroup < 0:
+		_this.groupOf[i] = jGroup
+		_this.ambiguousGroups[jGroup] = append(_this.ambiguousGroups[jGroup], i)
+	case iGroup != jGroup:
+		for _, k := range _this.ambiguousGroups[jGroup] {
+			_this.groupOf[k] = iGroup
+		}
+		_this.ambiguousGroups[iGroup] = append(_this.ambiguousGroups[iGroup], _this.ambiguousGroups[jGroup]...)
+		_this.ambiguousGroups[jGroup] = nil
+	}
+}
+
+func (_this *mapKeyIndex) isAmbiguous(i int) bool {
+	return _this.groupOf[i] >= 0
+}
+
+// Find the position of the key in the index that is equivalent to key.
+func (_this *comparator) findMapKey(index *mapKeyIndex, key reflect.Value) (found int, ok bool) {
+	if canonical, ok := _this.getCanonicalKey(key); ok {
+		if found, ok := index.keys[canonical]; ok {
+			return found, true
+		}
+		// It could still be equivalent to a key with no canonical form.
+		for _, i := range index.others {
+			if _this.areElementsEquivalent(key, index.all[i]) {
+				return i, true
+			}
+		}
+		return -1, false
+	}
+
+	for i, candidate := range index.all {
+		if _this.areElementsEquivalent(key, candidate) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Find the keys in index that are equivalent to key: its whole group if it's
+// ambiguous, or just the one key.
+func (_this *comparator) findMapKeyGroup(index *mapKeyIndex, key reflect.Value) []int {
+	found, ok := _this.findMapKey(index, key)
+	if !ok {
+		return nil
+	}
+	if index.isAmbiguous(found) {
+		return index.ambiguousGroups[index.groupOf[found]]
+	}
+	return []int{found}
+}
+
+// Returns true if the keys of aGroup (in a) can be paired one-to-one with the
+// keys of bGroup (in b) such that each pair has equivalent values. Since all of
+// these keys are equivalent to each other, it then doesn't matter which of
+// them is compared against which.
+func (_this *comparator) canPairKeyGroups(a, b reflect.Value, aIndex, bIndex *mapKeyIndex, aGroup, bGroup []int) bool {
+	if len(aGroup) != len(bGroup) {
+		return false
+	}
+	aMatches, _ := matchIndexes(len(aGroup), len(bGroup), func(i, j int) bool {
+		_this.pushMapKey(a, b, aIndex.all[aGroup[i]])
+		defer _this.popPath()
+		return _this.areElementsEquivalent(a.MapIndex(aIndex.all[aGroup[i]]), b.MapIndex(bIndex.all[bGroup[j]]))
+	})
+	for _, match := range aMatches {
+		if match < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Report every group of keys in a or b that are equivalent to each other,
+// unless the other map has an equivalent group that pairs up with it (see
+// canPairKeyGroups). Otherwise there's no way to tell which of them should be
+// compared against the other map. Returns false if any were reported.
+func (_this *comparator) reportAmbiguousKeys(a, b reflect.Value, aIndex, bIndex *mapKeyIndex) bool {
+	isEquivalent := true
+	isBGroupPaired := make(map[int]bool)
+	for _, aGroup := range aIndex.ambiguousGroups {
+		if len(aGroup) == 0 {
+			continue
+		}
+		bGroup := _this.findMapKeyGroup(bIndex, aIndex.all[aGroup[0]])
+		if _this.canPairKeyGroups(a, b, aIndex, bIndex, aGroup, bGroup) {
+			isBGroupPaired[bIndex.groupOf[bGroup[0]]] = true
+			continue
+		}
+		if !_this.reportAmbiguousKeyGroup(a, b, aIndex, aGroup, true) {
+			isEquivalent = false
+			if !_this.isCollecting() {
+				return false
+			}
+		}
+	}
+	for i, bGroup := range bIndex.ambiguousGroups {
+		if len(bGroup) == 0 || isBGroupPaired[i] {
+			continue
+		}
+		if !_this.reportAmbiguousKeyGroup(a, b, bIndex, bGroup, false) {
+			isEquivalent = false
+			if !_this.isCollecting() {
+				return false
+			}
+		}
+	}
+	return isEquivalent
+}
+
+// Report a group of keys in m (which is a if isA, or b otherwise) that are
+// equivalent to each other.
+func (_this *comparator) reportAmbiguousKeyGroup(a, b reflect.Value, index *mapKeyIndex, group []int, isA bool) bool {
+	m := b
+	if isA {
+		m = a
+	}
+	first := index.all[group[0]]
+	others := make([]string, 0, len(group)-1)
+	for _, i := range group[1:] {
+		other := unwrapInterface(index.all[i])
+		others = append(others, fmt.Sprintf("%v (%v)", describeValue(other), typeOfValue(other)))
+	}
+	note := "equivalent to " + strings.Join(others, ", ")
+	value := unwrapInterface(m.MapIndex(first))
+
+	_this.pushMapKey(a, b, first)
+	defer _this.popPath()
+	if isA {
+		return _this.failMissing(ReasonAmbiguousKey, note, value, SideB)
+	}
+	return _this.failMissing(ReasonAmbiguousKey, note, value, SideA)
+}
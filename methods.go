@@ -0,0 +1,138 @@
+package equivalence
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Equivalent can be implemented by types that know how to test their own
+// equivalence against other objects. It takes precedence over an Equal method
+// and the normal comparison rules.
+type Equivalent interface {
+	// Returns true if this object is equivalent to other. other will have
+	// been drilled down through pointers and interfaces.
+	EquivalentTo(other interface{}) bool
+}
+
+var equivalentType = reflect.TypeOf((*Equivalent)(nil)).Elem()
+var boolType = reflect.TypeOf(true)
+
+// How a value of some type can be asked whether it equals something else.
+type equalityMethods struct {
+	implementsEquivalent bool
+	// Index of an `Equal(T) bool` style method, or -1 if there isn't one.
+	equalIndex int
+	// The type of the Equal method's parameter
+	equalArgType reflect.Type
+}
+
+func (_this *equalityMethods) hasMethods() bool {
+	return _this.implementsEquivalent || _this.equalIndex >= 0
+}
+
+func newEqualityMethods(t reflect.Type) equalityMethods {
+	methods := equalityMethods{
+		implementsEquivalent: t.Implements(equivalentType),
+		equalIndex:           -1,
+	}
+	if method, ok := t.MethodByName("Equal"); ok {
+		methodType := method.Type
+		// In(0) is the receiver
+		if methodType.NumIn() == 2 && methodType.NumOut() == 1 && methodType.Out(0) == boolType {
+			methods.equalIndex = method.Index
+			methods.equalArgType = methodType.In(1)
+		}
+	}
+	return methods
+}
+
+// The equality methods available to a type T, and to *T.
+type typeEqualityMethods struct {
+	value   equalityMethods
+	pointer equalityMethods
+}
+
+// Cache of reflect.Type -> *typeEqualityMethods
+var equalityMethodsCache sync.Map
+
+func getEqualityMethods(t reflect.Type) *typeEqualityMethods {
+	if methods, ok := equalityMethodsCache.Load(t); ok {
+		return methods.(*typeEqualityMethods)
+	}
+	methods := &typeEqualityMethods{
+		value:   newEqualityMethods(t),
+		pointer: newEqualityMethods(reflect.PtrTo(t)),
+	}
+	equalityMethodsCache.Store(t, methods)
+	return methods
+}
+
+// Get a pointer to v, copying v if it isn't addressable.
+func getPointerTo(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v.Addr()
+	}
+	pointer := reflect.New(v.Type())
+	pointer.Elem().Set(v)
+	return pointer
+}
+
+// Get v in a form that can be passed as an argument of type argType.
+func getArgument(v reflect.Value, argType reflect.Type) (arg reflect.Value, ok bool) {
+	if v.Type().AssignableTo(argType) {
+		return v, true
+	}
+	if reflect.PtrTo(v.Type()).AssignableTo(argType) {
+		return getPointerTo(v), true
+	}
+	return v, false
+}
+
+// Ask receiver whether it's equivalent to b, using EquivalentTo() or Equal().
+func callEqualityMethodOn(receiver reflect.Value, methods *equalityMethods, b reflect.Value) (isEquivalent bool, ok bool) {
+	if methods.implementsEquivalent {
+		return receiver.Interface().(Equivalent).EquivalentTo(b.Interface()), true
+	}
+	if methods.equalIndex >= 0 {
+		if arg, ok := getArgument(b, methods.equalArgType); ok {
+			result := receiver.Method(methods.equalIndex).Call([]reflect.Value{arg})
+			return result[0].Bool(), true
+		}
+	}
+	return false, false
+}
+
+// Ask a whether it's equivalent to b, also trying methods with a pointer
+// receiver.
+func callEqualityMethod(a, b reflect.Value) (isEquivalent bool, ok bool) {
+	t := a.Type()
+	// Only named types and structs (which can embed named types) have methods.
+	if t.Name() == "" && t.Kind() != reflect.Struct {
+		return false, false
+	}
+	methods := getEqualityMethods(t)
+	if isEquivalent, ok = callEqualityMethodOn(a, &methods.value, b); ok {
+		return
+	}
+	if methods.pointer.hasMethods() {
+		return callEqualityMethodOn(getPointerTo(a), &methods.pointer, b)
+	}
+	return false, false
+}
+
+// Test a and b for equivalence using their EquivalentTo() or Equal() methods,
+// trying a's methods first. ok will be false if neither value has a usable
+// method.
+func (_this *comparator) tryEqualityMethods(a, b reflect.Value) (isEquivalent bool, ok bool) {
+	if _this.options.ignoreEqualityMethods {
+		return false, false
+	}
+	// Values taken from unexported fields can't be used to call methods.
+	if !a.CanInterface() || !b.CanInterface() {
+		return false, false
+	}
+	if isEquivalent, ok = callEqualityMethod(a, b); ok {
+		return
+	}
+	return callEqualityMethod(b, a)
+}
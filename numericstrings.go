@@ -0,0 +1,73 @@
+package equivalence
+
+import (
+	"math/big"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// NumericStringFlags loosen the rules for parsing strings as numbers (see
+// ParseNumericStrings). Flags can be combined using `|`.
+type NumericStringFlags int
+
+const (
+	// Ignore whitespace before and after the number.
+	NumericStringsAllowWhitespace NumericStringFlags = 1 << iota
+	// Allow a leading `+` sign.
+	NumericStringsAllowPlusSign
+)
+
+// An optionally negative decimal number, with an optional fraction and
+// exponent.
+var numericStringPattern = regexp.MustCompile(`^-?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+// Parse a string as an exact number, using the rules in flags.
+func parseNumericString(s string, flags NumericStringFlags) (*big.Rat, bool) {
+	if flags&NumericStringsAllowWhitespace != 0 {
+		s = strings.TrimSpace(s)
+	}
+	if flags&NumericStringsAllowPlusSign != 0 && strings.HasPrefix(s, "+") {
+		s = s[1:]
+		if strings.HasPrefix(s, "-") {
+			return nil, false
+		}
+	}
+	if !numericStringPattern.MatchString(s) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// Returns true if a and b should be compared by parsing one of them as a
+// number (see ParseNumericStrings).
+func (_this *comparator) shouldParseNumericString(a, b reflect.Value) bool {
+	if !_this.options.parseNumericStrings {
+		return false
+	}
+	return a.Kind() == reflect.String && isNumber(b) ||
+		b.Kind() == reflect.String && isNumber(a)
+}
+
+func isNumber(v reflect.Value) bool {
+	return getNumericClass(v) != numericClassNone || isComplex(v)
+}
+
+// Compare a string against a number (in either order) by parsing the string.
+func (_this *comparator) areNumericStringAndNumberEquivalent(a, b reflect.Value) bool {
+	stringIsA := a.Kind() == reflect.String
+	s := a
+	if !stringIsA {
+		s = b
+	}
+	r, ok := parseNumericString(s.String(), _this.options.numericStringFlags)
+	if !ok {
+		return _this.fail(ReasonTypeMismatch, a, b)
+	}
+
+	number := reflect.ValueOf(*r)
+	if stringIsA {
+		return _this.compareSubstitutes(a, b, number, b, _this.areNumbersEquivalent)
+	}
+	return _this.compareSubstitutes(a, b, a, number, _this.areNumbersEquivalent)
+}
@@ -0,0 +1,274 @@
+package equivalence
+
+import (
+	"reflect"
+	"time"
+)
+
+// Option configures how objects are compared. Pass options to
+// IsEquivalentWith or CompareWith to change individual rules.
+type Option func(*options)
+
+// The comparison rules. The zero value gives the default rules used by
+// IsEquivalent and Compare.
+type options struct {
+	distinguishNilFromEmpty     bool
+	floatTolerance              floatTolerance
+	matchStructFieldsByPosition bool
+	unorderedSlices             bool
+	unorderedPaths              map[string]bool
+	comparers                   map[reflect.Type]comparerFunc
+	ignoreEqualityMethods       bool
+	nanPolicy                   NaNPolicy
+	distinguishSignedZero       bool
+	exactTypeKinds              KindGroup
+	preserveAliasing            bool
+	missingMapKeysAsZero        bool
+	compareBytesAndRunesAsText  bool
+	parseNumericStrings         bool
+	numericStringFlags          NumericStringFlags
+	requireSameTimeLocation     bool
+	timePrecision               time.Duration
+	timeTolerance               time.Duration
+}
+
+func newOptions(opts []Option) options {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// DistinguishNilFromEmpty makes nil slices and maps not equivalent to empty
+// ones. By default, a nil slice or map is equivalent to any empty slice, array,
+// or map of a compatible kind.
+func DistinguishNilFromEmpty() Option {
+	return func(o *options) {
+		o.distinguishNilFromEmpty = true
+	}
+}
+
+// MatchStructFieldsByPosition compares struct fields by their position rather
+// than by their name, so that the structs must have the same number of fields,
+// but the field names don't matter.
+//
+// By default, fields are matched by name, and a field that exists on only one
+// side makes the structs not equivalent.
+func MatchStructFieldsByPosition() Option {
+	return func(o *options) {
+		o.matchStructFieldsByPosition = true
+	}
+}
+
+// UnorderedSlices compares all arrays and slices as multisets: every element
+// on one side must be equivalent to a different element on the other side,
+// regardless of position. Elements that can't be paired up are reported as
+// unmatched.
+//
+// Individual struct fields can be compared this way using the
+// `equivalence:"unordered"` struct tag.
+func UnorderedSlices() Option {
+	return func(o *options) {
+		o.unorderedSlices = true
+	}
+}
+
+// UnorderedSlicesAt compares the arrays and slices at the given paths as
+// multisets (see UnorderedSlices). Paths are written the same way as in
+// Difference.Path, such as `.Results` or `["x"].Items`, with an empty string
+// being the root object.
+func UnorderedSlicesAt(paths ...string) Option {
+	return func(o *options) {
+		if o.unorderedPaths == nil {
+			o.unorderedPaths = make(map[string]bool)
+		}
+		for _, path := range paths {
+			o.unorderedPaths[path] = true
+		}
+	}
+}
+
+// IgnoreEqualityMethods compares objects using only the normal rules, even if
+// they implement Equivalent or have an Equal method.
+func IgnoreEqualityMethods() Option {
+	return func(o *options) {
+		o.ignoreEqualityMethods = true
+	}
+}
+
+// CompareBytesAndRunesAsText makes strings, byte slices, byte arrays, and rune
+// slices equivalent to each other if they hold the same text, so that "abc",
+// []byte("abc"), and []rune("abc") are all equivalent. Trailing zero bytes in
+// byte arrays are treated as padding, so [8]byte{'a', 'b', 'c'} is also
+// equivalent to "abc" and to []byte("abc"). Byte and rune slices that are
+// equivalent element by element (such as []byte{200} and []rune{200}) remain
+// equivalent.
+//
+// By default, byte and rune slices are only compared element by element
+// against other arrays and slices.
+func CompareBytesAndRunesAsText() Option {
+	return func(o *options) {
+		o.compareBytesAndRunesAsText = true
+	}
+}
+
+// ParseNumericStrings makes a string (including named string types such as
+// json.Number) equivalent to a number if it parses as exactly that number. For
+// example, "10", "1e1", and "10.0" are equivalent to 10, and "0.5" is
+// equivalent to 0.5. Integers can be of any size.
+//
+// Parsing is strict: only optionally negative decimal numbers with an optional
+// fraction and exponent are accepted, and the parsed value must match exactly,
+// so "0.1" is not equivalent to float64(0.1) (which is only an approximation of
+// 0.1) unless a float tolerance is set. flags can allow surrounding whitespace
+// and a leading plus sign.
+//
+// Strings are still compared to other strings as text.
+func ParseNumericStrings(flags NumericStringFlags) Option {
+	return func(o *options) {
+		o.parseNumericStrings = true
+		o.numericStringFlags = flags
+	}
+}
+
+// MissingMapKeysAsZero treats a map key that only exists on one side as if the
+// other map contained the zero value of its element type for that key, so that
+// map[string]int{"a": 0} is equivalent to map[string]int{}. If the element
+// type is an interface, the value is compared to the zero value of its own
+// type (so nil, 0, and "" all match a missing key).
+//
+// By default, a key that only exists on one side makes the maps not
+// equivalent, whatever its value.
+func MissingMapKeysAsZero() Option {
+	return func(o *options) {
+		o.missingMapKeysAsZero = true
+	}
+}
+
+// PreserveAliasing requires both objects to share references in the same way:
+// if two places in a refer to the same object, the corresponding places in b
+// must also refer to a single object (and vice versa). Each object in a is
+// paired with the first object in b that it's compared to, and any place where
+// that pairing is broken is reported as ReasonAliasingDiffers.
+//
+// Pointers, maps, and slices (by their backing array and length) are tracked.
+// Places where only one side is a reference are compared by value as usual.
+// Elements of unordered arrays and slices are each checked on their own.
+func PreserveAliasing() Option {
+	return func(o *options) {
+		o.preserveAliasing = true
+	}
+}
+
+// RequireExactTypes makes values in the given kind groups only equivalent to
+// values of the exact same type, while everything else is still compared
+// without regard to type. For example, RequireExactTypes(NumericKinds) makes
+// int32(1) and int64(1) not equivalent, but still allows a struct to be
+// equivalent to a differently named struct with the same fields.
+//
+// Values are still drilled down through pointers and interfaces before their
+// types are checked. RequireExactTypes(AllKinds) makes every comparison type
+// strict, similar to reflect.DeepEqual.
+//
+// Calling this multiple times adds to the kind groups that require exact
+// types.
+func RequireExactTypes(kinds KindGroup) Option {
+	return func(o *options) {
+		o.exactTypeKinds |= kinds
+	}
+}
+
+// WithNaNPolicy sets when NaN values are equivalent to each other. The default
+// is NaNsAreEquivalent. The policy also applies to the real and imaginary parts
+// of complex numbers.
+func WithNaNPolicy(policy NaNPolicy) Option {
+	return func(o *options) {
+		o.nanPolicy = policy
+	}
+}
+
+// DistinguishSignedZero makes negative zero not equivalent to positive zero.
+// This applies to floats, big.Float, and the parts of complex numbers. Integer
+// zero is considered positive.
+func DistinguishSignedZero() Option {
+	return func(o *options) {
+		o.distinguishSignedZero = true
+	}
+}
+
+// FloatAbsoluteTolerance makes numeric values equivalent if they differ by no
+// more than epsilon. This only applies when at least one of the values is a
+// float or big.Float.
+//
+// If several tolerances are given, values within any of them are equivalent.
+func FloatAbsoluteTolerance(epsilon float64) Option {
+	return func(o *options) {
+		o.floatTolerance.absolute = epsilon
+	}
+}
+
+// FloatRelativeTolerance makes numeric values equivalent if they differ by no
+// more than epsilon times the larger of their magnitudes. This only applies
+// when at least one of the values is a float or big.Float.
+//
+// If several tolerances are given, values within any of them are equivalent.
+func FloatRelativeTolerance(epsilon float64) Option {
+	return func(o *options) {
+		o.floatTolerance.relative = epsilon
+	}
+}
+
+// FloatULPTolerance makes numeric values equivalent if they are no more than
+// maxULPs representable floats apart. Distance is measured in float32 steps if
+// either value is a float32, and float64 steps otherwise. This only applies
+// when at least one of the values is a float or big.Float.
+//
+// If several tolerances are given, values within any of them are equivalent.
+func FloatULPTolerance(maxULPs uint64) Option {
+	return func(o *options) {
+		o.floatTolerance.ulps = maxULPs
+	}
+}
+
+// WithComparer uses comparer for comparisons involving a value of type t (on
+// either side), in place of the normal rules. It takes precedence over any
+// comparer registered for t using RegisterComparer. A nil comparer disables
+// any globally registered comparer for t.
+func WithComparer(t reflect.Type, comparer Comparer) Option {
+	return func(o *options) {
+		if o.comparers == nil {
+			o.comparers = make(map[reflect.Type]comparerFunc)
+		}
+		if comparer == nil {
+			o.comparers[t] = nil
+			return
+		}
+		o.comparers[t] = wrapComparer(t, comparer)
+	}
+}
+
+// RequireSameTimeLocation makes time.Time values only equivalent if they are
+// the same instant in locations with the same name. By default, times are
+// equivalent if they are the same instant, whatever their location.
+func RequireSameTimeLocation() Option {
+	return func(o *options) {
+		o.requireSameTimeLocation = true
+	}
+}
+
+// TruncateTimes truncates time.Time values to a multiple of precision (see
+// time.Time.Truncate) before comparing them.
+func TruncateTimes(precision time.Duration) Option {
+	return func(o *options) {
+		o.timePrecision = precision
+	}
+}
+
+// TimeTolerance makes time.Time values equivalent if they are no more than
+// window apart.
+func TimeTolerance(window time.Duration) Option {
+	return func(o *options) {
+		o.timeTolerance = window
+	}
+}
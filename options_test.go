@@ -0,0 +1,584 @@
+package equivalence
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kstenerud/go-describe"
+)
+
+func assertEquivalentWith(t *testing.T, a, b interface{}, opts ...Option) {
+	if !IsEquivalentWith(a, b, opts...) {
+		t.Errorf("Expected %v (%v) and %v (%v) to be equivalent", describe.D(a), reflect.TypeOf(a), describe.D(b), reflect.TypeOf(b))
+	}
+	if result := CompareWith(a, b, opts...); !result.IsEquivalent() {
+		t.Errorf("Expected no differences between %v and %v, but got:\n%v", describe.D(a), describe.D(b), result)
+	}
+}
+
+func assertNotEquivalentWith(t *testing.T, a, b interface{}, opts ...Option) {
+	if IsEquivalentWith(a, b, opts...) {
+		t.Errorf("Expected %v (%v) and %v (%v) to not be equivalent", describe.D(a), reflect.TypeOf(a), describe.D(b), reflect.TypeOf(b))
+	}
+	if result := CompareWith(a, b, opts...); result.IsEquivalent() {
+		t.Errorf("Expected differences between %v and %v, but got none", describe.D(a), describe.D(b))
+	}
+}
+
+func TestNoOptions(t *testing.T) {
+	assertEquivalentWith(t, 1, int8(1))
+	assertNotEquivalentWith(t, 1, 2)
+}
+
+func TestDistinguishNilFromEmpty(t *testing.T) {
+	var nilSlice []int
+	var nilMap map[string]int
+
+	assertEquivalentWith(t, nilSlice, []int{})
+	assertEquivalentWith(t, nilMap, map[string]int{})
+
+	assertNotEquivalentWith(t, nilSlice, []int{}, DistinguishNilFromEmpty())
+	assertNotEquivalentWith(t, []string{}, nilSlice, DistinguishNilFromEmpty())
+	assertNotEquivalentWith(t, nilSlice, [0]int{}, DistinguishNilFromEmpty())
+	assertNotEquivalentWith(t, nilMap, map[string]int{}, DistinguishNilFromEmpty())
+	assertEquivalentWith(t, nilSlice, []string(nil), DistinguishNilFromEmpty())
+	assertEquivalentWith(t, []int{}, []string{}, DistinguishNilFromEmpty())
+	assertEquivalentWith(t, nilMap, map[int]int(nil), DistinguishNilFromEmpty())
+	assertNotEquivalentWith(t, nilMap, []int{}, DistinguishNilFromEmpty())
+	assertEquivalentWith(t, nilMap, nilSlice, DistinguishNilFromEmpty())
+}
+
+func TestFloatAbsoluteTolerance(t *testing.T) {
+	tenth, fifth := 0.1, 0.2
+	assertNotEquivalentWith(t, tenth+fifth, 0.3)
+	assertEquivalentWith(t, tenth+fifth, 0.3, FloatAbsoluteTolerance(1e-9))
+	assertEquivalentWith(t, 1.0000001, 1, FloatAbsoluteTolerance(1e-6))
+	assertEquivalentWith(t, 1, 1.0000001, FloatAbsoluteTolerance(1e-6))
+	assertEquivalentWith(t, uint(1), 0.9999999, FloatAbsoluteTolerance(1e-6))
+	assertEquivalentWith(t, big.NewInt(1), 1.0000001, FloatAbsoluteTolerance(1e-6))
+	assertEquivalentWith(t, big.NewFloat(1.0000001), 1, FloatAbsoluteTolerance(1e-6))
+	assertEquivalentWith(t, big.NewFloat(1.0000001), big.NewInt(1), FloatAbsoluteTolerance(1e-6))
+	assertNotEquivalentWith(t, 1.1, 1, FloatAbsoluteTolerance(1e-6))
+	assertNotEquivalentWith(t, 1, 2, FloatAbsoluteTolerance(10))
+	assertNotEquivalentWith(t, math.Inf(1), math.MaxFloat64, FloatAbsoluteTolerance(math.Inf(1)))
+}
+
+func TestFloatRelativeTolerance(t *testing.T) {
+	assertEquivalentWith(t, 1000000.0, 1000001, FloatRelativeTolerance(1e-6))
+	assertNotEquivalentWith(t, 1.0, 2, FloatRelativeTolerance(1e-6))
+	assertEquivalentWith(t, float32(1)/3, 1.0/3, FloatRelativeTolerance(1e-7))
+	assertNotEquivalentWith(t, float32(1)/3, 1.0/3, FloatRelativeTolerance(1e-9))
+}
+
+func TestFloatULPTolerance(t *testing.T) {
+	assertEquivalentWith(t, 1.0, math.Nextafter(1, 2), FloatULPTolerance(1))
+	assertNotEquivalentWith(t, 1.0, math.Nextafter(math.Nextafter(1, 2), 2), FloatULPTolerance(1))
+	assertEquivalentWith(t, 0.0, math.SmallestNonzeroFloat64, FloatULPTolerance(1))
+	assertEquivalentWith(t, math.Copysign(0, -1), math.SmallestNonzeroFloat64, FloatULPTolerance(1))
+	assertEquivalentWith(t, math.Copysign(0, -1), -math.SmallestNonzeroFloat64, FloatULPTolerance(1))
+	assertEquivalentWith(t, -math.SmallestNonzeroFloat64, math.SmallestNonzeroFloat64, FloatULPTolerance(2))
+	assertEquivalentWith(t, float32(1), math.Nextafter32(1, 2), FloatULPTolerance(1))
+	assertEquivalentWith(t, float32(1)/3, 1.0/3, FloatULPTolerance(1))
+}
+
+func TestFloatToleranceInDifference(t *testing.T) {
+	result := CompareWith([]float64{1.5}, []float64{1.6}, FloatAbsoluteTolerance(0.01), FloatULPTolerance(4))
+	expected := "[0]: value differs (outside absolute tolerance 0.01 or tolerance of 4 ULPs): 1.5 (float64) vs 1.6 (float64)"
+	if len(result.Differences) != 1 || result.Differences[0].String() != expected {
+		t.Errorf("Expected [%v] but got [%v]", expected, result)
+	}
+}
+
+func TestMatchStructFieldsByPosition(t *testing.T) {
+	assertEquivalentWith(t, MyStruct{1, "a"}, SameShapeStruct{1, "a"}, MatchStructFieldsByPosition())
+	assertNotEquivalentWith(t, MyStruct{1, "a"}, ReorderedStruct{"a", 1}, MatchStructFieldsByPosition())
+	assertNotEquivalentWith(t, MyStruct{1, "a"}, ExtendedStruct{1, "a", false}, MatchStructFieldsByPosition())
+}
+
+func TestStructTagsByPosition(t *testing.T) {
+	assertEquivalentWith(t, TaggedStruct{1, 2, "a"}, TaggedStruct{100, 2, "a"}, MatchStructFieldsByPosition())
+	assertEquivalentWith(t, IgnoredExtraStruct{1, "a", "x"}, ExtendedStruct{1, "a", true}, MatchStructFieldsByPosition())
+	assertNotEquivalentWith(t, TaggedStruct{1, 2, "a"}, TaggedStruct{1, 3, "a"}, MatchStructFieldsByPosition())
+}
+
+type UnorderedStruct struct {
+	Ordered   []int
+	Unordered []int `equivalence:"unordered"`
+}
+
+func TestUnorderedSlices(t *testing.T) {
+	assertNotEquivalentWith(t, []int{1, 2, 3}, []int{3, 1, 2})
+	assertEquivalentWith(t, []int{1, 2, 3}, []int{3, 1, 2}, UnorderedSlices())
+	assertEquivalentWith(t, []interface{}{1, "a"}, []interface{}{"a", int8(1)}, UnorderedSlices())
+	assertEquivalentWith(t, [3]int{1, 1, 2}, []uint{1, 2, 1}, UnorderedSlices())
+	assertNotEquivalentWith(t, []int{1, 1, 2}, []int{1, 2, 2}, UnorderedSlices())
+	assertNotEquivalentWith(t, []int{1, 2}, []int{1, 2, 2}, UnorderedSlices())
+	assertEquivalentWith(t, [][]int{{1, 2}, {3}}, [][]int{{3}, {2, 1}}, UnorderedSlices())
+}
+
+func TestUnorderedSlicesNeedsMaximumMatching(t *testing.T) {
+	// Greedily pairing 1.0 with 1.05 would leave 1.1 without a partner.
+	assertEquivalentWith(t, []float64{1.0, 1.1}, []float64{1.05, 0.95},
+		UnorderedSlices(), FloatAbsoluteTolerance(0.06))
+}
+
+func TestUnorderedSlicesMixedCanonicalForms(t *testing.T) {
+	// Numeric strings have no canonical form. Pairing 1 with 1.0 because they
+	// share one would leave "01" without a partner.
+	opt := ParseNumericStrings(0)
+	assertNotEquivalentWith(t, "01", "1", opt)
+	assertEquivalentWith(t, []interface{}{1, "01"}, []interface{}{1.0, "1"}, UnorderedSlices(), opt)
+	assertNotEquivalentWith(t, []interface{}{1, "01"}, []interface{}{2.0, "1"}, UnorderedSlices(), opt)
+}
+
+func TestUnorderedSlicesLarge(t *testing.T) {
+	a := make([]int, 3000)
+	b := make([]float64, len(a))
+	for i := range a {
+		a[i] = i / 2
+		b[len(b)-1-i] = float64(i / 2)
+	}
+	assertEquivalentWith(t, a, b, UnorderedSlices())
+	b[0] = -1
+	assertNotEquivalentWith(t, a, b, UnorderedSlices())
+	result := CompareWith(a, b, UnorderedSlices())
+	expected := "[2999]: unmatched element: 1499 (int) vs nil (<nil>)\n[B:0]: unmatched element: nil (<nil>) vs -1 (float64)"
+	if result.String() != expected {
+		t.Errorf("Expected:\n%v\nbut got:\n%v", expected, result)
+	}
+}
+
+func TestUnorderedSlicesAt(t *testing.T) {
+	a := map[string]interface{}{"x": []int{1, 2}, "y": []int{1, 2}}
+	b := map[string]interface{}{"x": []int{2, 1}, "y": []int{2, 1}}
+	assertNotEquivalentWith(t, a, b, UnorderedSlicesAt(`["x"]`))
+	assertEquivalentWith(t, a, b, UnorderedSlicesAt(`["x"]`, `["y"]`))
+	assertEquivalentWith(t, []int{1, 2}, []int{2, 1}, UnorderedSlicesAt(""))
+}
+
+type TreeNode struct {
+	Value    int
+	Children []*TreeNode
+}
+
+// A node that is its own first child
+func newCyclicTreeNode(otherChildValue int) *TreeNode {
+	n := &TreeNode{}
+	n.Children = []*TreeNode{n, {Value: otherChildValue}}
+	return n
+}
+
+func TestUnorderedSlicesCyclic(t *testing.T) {
+	assertEquivalentWith(t, newCyclicTreeNode(0), newCyclicTreeNode(0))
+	assertEquivalentWith(t, newCyclicTreeNode(0), newCyclicTreeNode(0), UnorderedSlices())
+	assertNotEquivalentWith(t, newCyclicTreeNode(0), newCyclicTreeNode(1), UnorderedSlices())
+
+	reordered := &TreeNode{}
+	reordered.Children = []*TreeNode{{}, reordered}
+	assertEquivalentWith(t, newCyclicTreeNode(0), reordered, UnorderedSlices())
+}
+
+func TestUnorderedSlicesAtCyclic(t *testing.T) {
+	assertEquivalentWith(t, newCyclicTreeNode(0), newCyclicTreeNode(0), UnorderedSlicesAt(".Children"))
+	assertNotEquivalentWith(t, newCyclicTreeNode(0), newCyclicTreeNode(1), UnorderedSlicesAt(".Children"))
+
+	reordered := &TreeNode{}
+	reordered.Children = []*TreeNode{{}, reordered}
+	assertEquivalentWith(t, newCyclicTreeNode(0), reordered, UnorderedSlicesAt(".Children"))
+	assertNotEquivalentWith(t, newCyclicTreeNode(0), reordered)
+}
+
+func TestUnorderedStructTag(t *testing.T) {
+	assertEquivalentWith(t, UnorderedStruct{[]int{1, 2}, []int{1, 2}}, UnorderedStruct{[]int{1, 2}, []int{2, 1}})
+	assertNotEquivalentWith(t, UnorderedStruct{[]int{1, 2}, []int{1, 2}}, UnorderedStruct{[]int{2, 1}, []int{2, 1}})
+}
+
+type UnorderedFirstStruct struct {
+	Unordered []int `equivalence:"unordered"`
+	Ordered   []int
+}
+
+func TestUnorderedSharedSlices(t *testing.T) {
+	// The same pair of slices compared in unordered mode first mustn't count
+	// as already compared in ordered mode.
+	s := []int{1, 2}
+	p := []int{2, 1}
+	assertNotEquivalentWith(t, UnorderedFirstStruct{s, s}, UnorderedFirstStruct{p, p})
+	assertNotEquivalentWith(t, UnorderedStruct{s, s}, UnorderedStruct{p, p})
+	assertEquivalentWith(t, UnorderedFirstStruct{s, p}, UnorderedFirstStruct{p, p})
+
+	a := map[string]interface{}{"x": s, "y": s}
+	b := map[string]interface{}{"x": p, "y": p}
+	assertNotEquivalentWith(t, a, b, UnorderedSlicesAt(`["x"]`))
+	assertNotEquivalentWith(t, a, b, UnorderedSlicesAt(`["y"]`))
+	assertEquivalentWith(t, a, b, UnorderedSlicesAt(`["x"]`, `["y"]`))
+}
+
+func TestUnorderedSlicesDifferences(t *testing.T) {
+	result := CompareWith([]interface{}{1, "a", 2}, []interface{}{"a", 3, 1}, UnorderedSlices())
+	expected := "[2]: unmatched element: 2 (int) vs nil (<nil>)\n[B:1]: unmatched element: nil (<nil>) vs 3 (int)"
+	if result.String() != expected {
+		t.Errorf("Expected:\n%v\nbut got:\n%v", expected, result)
+	}
+}
+
+type Money struct {
+	Cents    int64
+	Currency string
+}
+
+// Money is equivalent to another Money with the same value, or to a number of
+// whole dollars.
+func compareMoney(a, b reflect.Value) bool {
+	money := a.Interface().(Money)
+	switch b.Kind() {
+	case reflect.Int:
+		return money.Cents == b.Int()*100
+	case reflect.Struct:
+		if other, ok := b.Interface().(Money); ok {
+			return money == other
+		}
+	}
+	return false
+}
+
+func TestWithComparer(t *testing.T) {
+	moneyType := reflect.TypeOf(Money{})
+	assertNotEquivalentWith(t, Money{500, "USD"}, 5)
+	assertEquivalentWith(t, Money{500, "USD"}, 5, WithComparer(moneyType, compareMoney))
+	assertEquivalentWith(t, 5, &Money{500, "USD"}, WithComparer(moneyType, compareMoney))
+	assertEquivalentWith(t, []interface{}{Money{500, "USD"}}, []int{5}, WithComparer(moneyType, compareMoney))
+	assertNotEquivalentWith(t, Money{501, "USD"}, 5, WithComparer(moneyType, compareMoney))
+	assertNotEquivalentWith(t, Money{500, "USD"}, "5", WithComparer(moneyType, compareMoney))
+}
+
+func TestRegisterComparer(t *testing.T) {
+	moneyType := reflect.TypeOf(Money{})
+	RegisterComparer(moneyType, compareMoney)
+	defer RegisterComparer(moneyType, nil)
+
+	assertEquivalent(t, Money{500, "USD"}, 5)
+	assertEquivalent(t, 5, Money{500, "USD"})
+	assertNotEquivalentWith(t, Money{500, "USD"}, 5, WithComparer(moneyType, nil))
+	alwaysEqual := func(a, b reflect.Value) bool { return true }
+	assertEquivalentWith(t, Money{500, "USD"}, 6, WithComparer(moneyType, alwaysEqual))
+}
+
+func TestReplaceBuiltInComparer(t *testing.T) {
+	alwaysEqual := func(a, b reflect.Value) bool { return true }
+	assertNotEquivalent(t, big.NewInt(1), 2)
+	assertEquivalentWith(t, big.NewInt(1), 2, WithComparer(reflect.TypeOf(big.Int{}), alwaysEqual))
+	assertEquivalentWith(t, 2, big.NewInt(1), WithComparer(reflect.TypeOf(big.Int{}), alwaysEqual))
+}
+
+func TestIgnoreEqualityMethods(t *testing.T) {
+	assertNotEquivalentWith(t, CaseInsensitive("Hello"), CaseInsensitive("hELLO"), IgnoreEqualityMethods())
+	assertNotEquivalentWith(t, &Version{1, 2, "a"}, &Version{1, 2, "b"}, IgnoreEqualityMethods())
+	assertEquivalentWith(t, &Version{1, 2, "a"}, &Version{1, 2, "a"}, IgnoreEqualityMethods())
+}
+
+func TestNaNPolicy(t *testing.T) {
+	nan1 := math.Float64frombits(0x7ff8000000000001)
+	nan2 := math.Float64frombits(0x7ff8000000000002)
+
+	assertEquivalentWith(t, nan1, nan2)
+	assertEquivalentWith(t, float32(math.NaN()), math.NaN())
+	assertNotEquivalentWith(t, math.NaN(), 1.0)
+
+	assertEquivalentWith(t, nan1, nan1, WithNaNPolicy(NaNsMatchPayload))
+	assertNotEquivalentWith(t, nan1, nan2, WithNaNPolicy(NaNsMatchPayload))
+
+	assertNotEquivalentWith(t, nan1, nan1, WithNaNPolicy(NaNsNeverEquivalent))
+	assertNotEquivalentWith(t, []float64{nan1}, []float64{nan1}, WithNaNPolicy(NaNsNeverEquivalent))
+	assertNotEquivalentWith(t, nan1, nan1, WithNaNPolicy(NaNsNeverEquivalent), FloatAbsoluteTolerance(1))
+}
+
+func TestNaNPolicyComplex(t *testing.T) {
+	nan1 := math.Float64frombits(0x7ff8000000000001)
+	nan2 := math.Float64frombits(0x7ff8000000000002)
+
+	assertEquivalentWith(t, complex(nan1, 1), complex(nan2, 1))
+	assertEquivalentWith(t, complex(1, nan1), complex64(complex(1, float32(nan1))))
+	assertNotEquivalentWith(t, complex(nan1, 1), complex(nan2, 1), WithNaNPolicy(NaNsMatchPayload))
+	assertNotEquivalentWith(t, complex(nan1, 1), complex(nan1, 1), WithNaNPolicy(NaNsNeverEquivalent))
+	assertEquivalentWith(t, complex(nan1, 0), nan2)
+	assertEquivalentWith(t, nan1, complex(nan2, 0))
+	assertNotEquivalentWith(t, complex(nan1, 0), nan2, WithNaNPolicy(NaNsMatchPayload))
+	assertNotEquivalentWith(t, complex(nan1, 0), nan1, WithNaNPolicy(NaNsNeverEquivalent))
+	assertNotEquivalentWith(t, complex(1, nan1), 1)
+}
+
+func TestDistinguishSignedZero(t *testing.T) {
+	negativeZero := math.Copysign(0, -1)
+	negativeBigZero := new(big.Float).Neg(big.NewFloat(0))
+
+	assertEquivalentWith(t, negativeZero, 0.0)
+	assertEquivalentWith(t, negativeZero, 0)
+	assertEquivalentWith(t, negativeBigZero, 0.0)
+
+	assertNotEquivalentWith(t, negativeZero, 0.0, DistinguishSignedZero())
+	assertNotEquivalentWith(t, negativeZero, 0, DistinguishSignedZero())
+	assertNotEquivalentWith(t, negativeZero, big.NewFloat(0), DistinguishSignedZero())
+	assertNotEquivalentWith(t, negativeBigZero, big.NewInt(0), DistinguishSignedZero())
+	assertEquivalentWith(t, negativeZero, float32(negativeZero), DistinguishSignedZero())
+	assertEquivalentWith(t, negativeZero, negativeBigZero, DistinguishSignedZero())
+	assertEquivalentWith(t, 0.0, uint(0), DistinguishSignedZero())
+	assertEquivalentWith(t, complex(0, 0), complex(negativeZero, 0))
+	assertNotEquivalentWith(t, complex(0, 0), complex(negativeZero, 0), DistinguishSignedZero())
+	assertEquivalentWith(t, complex(1, negativeZero), 1)
+	assertNotEquivalentWith(t, complex(1, negativeZero), 1, DistinguishSignedZero())
+	assertNotEquivalentWith(t, complex(negativeZero, 0), 0, DistinguishSignedZero())
+}
+
+type MyInt int
+
+func TestRequireExactTypes(t *testing.T) {
+	assertNotEquivalentWith(t, int32(1), int64(1), RequireExactTypes(NumericKinds))
+	assertNotEquivalentWith(t, 1, MyInt(1), RequireExactTypes(NumericKinds))
+	assertNotEquivalentWith(t, 1, big.NewInt(1), RequireExactTypes(NumericKinds))
+	assertNotEquivalentWith(t, complex64(1), complex128(1), RequireExactTypes(NumericKinds))
+	assertEquivalentWith(t, int64(1), int64(1), RequireExactTypes(NumericKinds))
+	assertEquivalentWith(t, []int32{1}, []int32{1}, RequireExactTypes(NumericKinds))
+	assertNotEquivalentWith(t, []int32{1}, []int64{1}, RequireExactTypes(NumericKinds))
+	assertNotEquivalentWith(t, []interface{}{int32(1)}, []interface{}{int64(1)}, RequireExactTypes(NumericKinds))
+
+	// Other kinds remain type-blind
+	assertEquivalentWith(t, []int{1}, [1]int{1}, RequireExactTypes(NumericKinds))
+	assertEquivalentWith(t, MyStruct{1, "a"}, ReorderedStruct{"a", 1}, RequireExactTypes(NumericKinds))
+	assertNotEquivalentWith(t, MyStruct{1, "a"}, ReorderedStruct{"a", 1}, RequireExactTypes(StructKinds))
+	assertEquivalentWith(t, MyStruct{1, "a"}, MyStruct{1, "a"}, RequireExactTypes(StructKinds))
+	assertNotEquivalentWith(t, []int{1}, [1]int{1}, RequireExactTypes(ContainerKinds))
+	assertNotEquivalentWith(t, []int{1}, []interface{}{1}, RequireExactTypes(ContainerKinds))
+	assertEquivalentWith(t, []interface{}{int8(1)}, []interface{}{1}, RequireExactTypes(ContainerKinds))
+	assertNotEquivalentWith(t, []interface{}{int8(1)}, []interface{}{1}, RequireExactTypes(ContainerKinds), RequireExactTypes(NumericKinds))
+	assertEquivalentWith(t, &MyStruct{1, "a"}, MyStruct{1, "a"}, RequireExactTypes(AllKinds))
+}
+
+func TestRequireExactTypesDifference(t *testing.T) {
+	result := CompareWith(MyStruct{1, "a"}, map[string]interface{}{}, RequireExactTypes(AllKinds))
+	if len(result.Differences) != 1 || result.Differences[0].Reason != ReasonTypeMismatch {
+		t.Errorf("Expected a type mismatch but got %v", result)
+	}
+	result = CompareWith([]int{1}, []int8{1}, RequireExactTypes(ContainerKinds))
+	if len(result.Differences) != 1 || result.Differences[0].Reason != ReasonTypeMismatch {
+		t.Errorf("Expected a type mismatch but got %v", result)
+	}
+}
+
+func TestPreserveAliasing(t *testing.T) {
+	shared := &Node{Value: 1}
+	sharedCopy := &Node{Value: 1}
+	assertEquivalentWith(t, Pair{shared, shared}, Pair{sharedCopy, sharedCopy}, PreserveAliasing())
+	assertEquivalentWith(t, Pair{shared, shared}, Pair{&Node{Value: 1}, &Node{Value: 1}})
+	assertNotEquivalentWith(t, Pair{shared, shared}, Pair{&Node{Value: 1}, &Node{Value: 1}}, PreserveAliasing())
+	assertNotEquivalentWith(t, Pair{&Node{Value: 1}, &Node{Value: 1}}, Pair{shared, shared}, PreserveAliasing())
+	assertEquivalentWith(t, Pair{&Node{Value: 1}, &Node{Value: 1}}, Pair{&Node{Value: 1}, &Node{Value: 1}}, PreserveAliasing())
+
+	// Still type-blind
+	assertEquivalentWith(t, []interface{}{shared, shared}, []*Node{sharedCopy, sharedCopy}, PreserveAliasing())
+	assertEquivalentWith(t, []interface{}{shared, shared}, []Node{{Value: 1}, {Value: 1}}, PreserveAliasing())
+
+	sharedMap := map[string]int{"a": 1}
+	assertNotEquivalentWith(t, []interface{}{sharedMap, sharedMap},
+		[]interface{}{map[string]int{"a": 1}, map[string]int{"a": 1}}, PreserveAliasing())
+
+	sharedSlice := []int{1, 2}
+	assertNotEquivalentWith(t, [][]int{sharedSlice, sharedSlice}, [][]int{{1, 2}, {1, 2}}, PreserveAliasing())
+	assertEquivalentWith(t, [][]int{{}, {}}, [][]int{{}, {}}, PreserveAliasing())
+
+	// Cycles of different lengths unroll to the same values, but aren't the
+	// same shape.
+	ring1 := &Node{Value: 1}
+	ring1.Next = ring1
+	ring2 := &Node{Value: 1, Next: &Node{Value: 1}}
+	ring2.Next.Next = ring2
+	assertEquivalentWith(t, ring1, ring2)
+	assertNotEquivalentWith(t, ring1, ring2, PreserveAliasing())
+	assertNotEquivalentWith(t, ring2, ring1, PreserveAliasing())
+}
+
+func TestPreserveAliasingDifference(t *testing.T) {
+	shared := &Node{Value: 1}
+	result := CompareWith(Pair{shared, shared}, Pair{&Node{Value: 1}, &Node{Value: 1}}, PreserveAliasing())
+	if len(result.Differences) != 1 {
+		t.Fatalf("Expected one difference but got %v", result)
+	}
+	difference := result.Differences[0]
+	if difference.Path != ".Right" || difference.Reason != ReasonAliasingDiffers || difference.Note != "A is also at .Left, but B is not" {
+		t.Errorf("Unexpected difference %v", difference)
+	}
+}
+
+func TestMissingMapKeysAsZero(t *testing.T) {
+	assertEquivalentWith(t, map[string]interface{}{"a": nil}, map[string]interface{}{"b": nil}, MissingMapKeysAsZero())
+	assertEquivalentWith(t, map[string]int{"a": 0, "b": 1}, map[string]int{"b": 1}, MissingMapKeysAsZero())
+	assertEquivalentWith(t, map[string]int{"b": 1}, map[string]int{"a": 0, "b": 1}, MissingMapKeysAsZero())
+	assertEquivalentWith(t, map[string]interface{}{"a": 0, "b": "", "c": MyStruct{}}, map[string]interface{}{}, MissingMapKeysAsZero())
+	assertEquivalentWith(t, map[string]*int{"a": nil}, map[string]*int8{}, MissingMapKeysAsZero())
+	assertEquivalentWith(t, map[string]int{"a": 0}, map[string]int8{}, MissingMapKeysAsZero())
+	assertNotEquivalentWith(t, map[string]string{"a": ""}, map[string]int{}, MissingMapKeysAsZero())
+	assertNotEquivalentWith(t, map[string]int{"a": 1}, map[string]int{}, MissingMapKeysAsZero())
+	assertNotEquivalentWith(t, map[string]int{}, map[string]int{"a": 1}, MissingMapKeysAsZero())
+	assertNotEquivalentWith(t, map[string]interface{}{"a": []int{1}}, map[string]interface{}{}, MissingMapKeysAsZero())
+
+	result := CompareWith(map[string]int{"a": 1, "b": 0}, map[string]int{"c": 2}, MissingMapKeysAsZero())
+	if len(result.Differences) != 2 {
+		t.Errorf("Expected two differences but got %v", result)
+	}
+	for _, difference := range result.Differences {
+		if difference.Reason != ReasonMissingKey {
+			t.Errorf("Expected missing keys but got %v", result)
+		}
+	}
+}
+
+func TestBigRatTolerance(t *testing.T) {
+	assertEquivalentWith(t, big.NewRat(1, 3), 0.3333, FloatAbsoluteTolerance(0.001))
+	assertNotEquivalentWith(t, big.NewRat(1, 3), 0.3333, FloatAbsoluteTolerance(0.00001))
+	assertNotEquivalentWith(t, big.NewRat(1, 3), big.NewInt(0), FloatAbsoluteTolerance(1))
+	assertNotEquivalentWith(t, big.NewRat(1, 2), 0.5, RequireExactTypes(NumericKinds))
+}
+
+func TestComplexTolerance(t *testing.T) {
+	assertEquivalentWith(t, complex(1.001, 0), 1, FloatAbsoluteTolerance(0.01))
+	assertNotEquivalentWith(t, complex(1, 0.001), 1, FloatAbsoluteTolerance(0.01))
+	assertNotEquivalentWith(t, complex(1, 0), 1, RequireExactTypes(NumericKinds))
+}
+
+func TestCompareBytesAndRunesAsText(t *testing.T) {
+	opt := CompareBytesAndRunesAsText()
+	assertEquivalentWith(t, "héllo", []byte("héllo"), opt)
+	assertEquivalentWith(t, []byte("héllo"), "héllo", opt)
+	assertEquivalentWith(t, "héllo", []rune("héllo"), opt)
+	assertEquivalentWith(t, []rune("héllo"), []byte("héllo"), opt)
+	assertEquivalentWith(t, [5]byte{'a', 'b', 'c'}, "abc", opt)
+	assertEquivalentWith(t, Color("abc"), [3]byte{'a', 'b', 'c'}, opt)
+	assertEquivalentWith(t, []interface{}{"a", []byte("b")}, [][]byte{[]byte("a"), []byte("b")}, opt)
+	assertEquivalentWith(t, "", []byte(nil), opt)
+	assertEquivalentWith(t, []byte{1, 2}, []int{1, 2}, opt)
+	assertEquivalentWith(t, [2]byte{1, 2}, []int{1, 2}, opt)
+	assertEquivalentWith(t, []byte{200}, []rune{200}, opt)
+	assertEquivalentWith(t, []byte{200}, []rune{200})
+	assertEquivalentWith(t, [8]byte{'a', 'b', 'c'}, []byte("abc"), opt)
+	assertEquivalentWith(t, []byte("abc"), [8]byte{'a', 'b', 'c'}, opt)
+	assertEquivalentWith(t, [8]byte{'a', 'b', 'c'}, [3]byte{'a', 'b', 'c'}, opt)
+	assertEquivalentWith(t, []byte{2, 1}, []rune{1, 2}, opt, UnorderedSlices())
+
+	assertNotEquivalentWith(t, "abc", []byte("abc"))
+	assertNotEquivalentWith(t, "abc", []byte("abd"), opt)
+	assertNotEquivalentWith(t, "abc", []rune("ab"), opt)
+	assertNotEquivalentWith(t, "", []byte(nil), opt, DistinguishNilFromEmpty())
+	assertNotEquivalentWith(t, "abc", []byte("abc"), opt, RequireExactTypes(StringKinds))
+	assertNotEquivalentWith(t, "abc", []int{'a', 'b', 'c'}, opt)
+	assertNotEquivalentWith(t, [8]byte{'a', 'b', 'c'}, []byte("abd"), opt)
+	assertNotEquivalentWith(t, [8]byte{'a', 'b', 'c'}, []byte("abc"))
+
+	assertEquivalentWith(t, map[[3]byte]int{{'a', 'b', 'c'}: 1}, map[string]int{"abc": 1}, opt)
+	assertEquivalentWith(t, map[[2]byte]int{{1, 2}: 1}, map[[2]int]int{{1, 2}: 1}, opt)
+	assertNotEquivalentWith(t, map[interface{}]int{[3]byte{'a', 'b', 'c'}: 1, "abc": 1}, map[string]int{"abc": 1, "x": 1}, opt)
+}
+
+func TestParseNumericStrings(t *testing.T) {
+	opt := ParseNumericStrings(0)
+	assertEquivalentWith(t, "10", 10, opt)
+	assertEquivalentWith(t, 10, "10", opt)
+	assertEquivalentWith(t, "-10", int8(-10), opt)
+	assertEquivalentWith(t, "1e1", uint(10), opt)
+	assertEquivalentWith(t, "10.000", 10.0, opt)
+	assertEquivalentWith(t, "0.5", float32(0.5), opt)
+	assertEquivalentWith(t, ".5", 0.5, opt)
+	assertEquivalentWith(t, "1/3", "1/3", opt)
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	assertEquivalentWith(t, "123456789012345678901234567890", huge, opt)
+	assertEquivalentWith(t, "1.23456789012345678901234567890e29", huge, opt)
+	assertEquivalentWith(t, "18446744073709551615", uint64(math.MaxUint64), opt)
+	assertEquivalentWith(t, "0.1", big.NewRat(1, 10), opt)
+	assertEquivalentWith(t, json.Number("42"), 42, opt)
+	assertEquivalentWith(t, map[string]interface{}{"a": json.Number("1.5")}, map[string]float64{"a": 1.5}, opt)
+	assertEquivalentWith(t, "5", complex(5, 0), opt)
+	assertEquivalentWith(t, "0.1", 0.1, opt, FloatAbsoluteTolerance(1e-9))
+
+	assertNotEquivalentWith(t, "10", 10)
+	assertNotEquivalentWith(t, "10", 11, opt)
+	assertNotEquivalentWith(t, "0.1", 0.1, opt)
+	assertNotEquivalentWith(t, "1.5", 1, opt)
+	assertNotEquivalentWith(t, "18446744073709551616", uint64(math.MaxUint64), opt)
+	assertNotEquivalentWith(t, "10", "10.0", opt)
+	assertNotEquivalentWith(t, "1/2", 0.5, opt)
+	assertNotEquivalentWith(t, "0x10", 16, opt)
+	assertNotEquivalentWith(t, "NaN", math.NaN(), opt)
+	assertNotEquivalentWith(t, "1e", 1, opt)
+	assertNotEquivalentWith(t, "", 0, opt)
+	assertNotEquivalentWith(t, " 10", 10, opt)
+	assertNotEquivalentWith(t, "+10", 10, opt)
+	assertNotEquivalentWith(t, "10", 10, opt, RequireExactTypes(NumericKinds))
+
+	assertEquivalentWith(t, " 10\n", 10, ParseNumericStrings(NumericStringsAllowWhitespace))
+	assertNotEquivalentWith(t, "+10", 10, ParseNumericStrings(NumericStringsAllowWhitespace))
+	assertEquivalentWith(t, "+10", 10, ParseNumericStrings(NumericStringsAllowPlusSign))
+	assertNotEquivalentWith(t, "++10", 10, ParseNumericStrings(NumericStringsAllowPlusSign))
+	assertNotEquivalentWith(t, "+-10", -10, ParseNumericStrings(NumericStringsAllowPlusSign))
+	assertEquivalentWith(t, " +10 ", 10, ParseNumericStrings(NumericStringsAllowWhitespace|NumericStringsAllowPlusSign))
+
+	assertEquivalentWith(t, map[string]int{"1": 1, "2": 2}, map[int]int{1: 1, 2: 2}, opt)
+	assertEquivalentWith(t, map[interface{}]int{"1": 1, "01": 1}, map[string]int{"01": 1, "1": 1}, opt)
+	assertNotEquivalentWith(t, map[interface{}]int{"1": 1, 1: 1}, map[int]int{1: 1, 2: 1}, opt)
+
+	result := CompareWith("1.5", 1, opt)
+	if len(result.Differences) != 1 || result.Differences[0].Reason != ReasonLossyConversion || result.Differences[0].A.Kind() != reflect.String {
+		t.Errorf("Expected a lossy conversion but got %v", result)
+	}
+}
+
+func TestRequireSameTimeLocation(t *testing.T) {
+	now := time.Now()
+	assertEquivalentWith(t, now.UTC(), now.UTC().Round(0), RequireSameTimeLocation())
+	assertEquivalentWith(t, now.In(time.FixedZone("X", 3600)), now.In(time.FixedZone("X", 3600)), RequireSameTimeLocation())
+	assertNotEquivalentWith(t, now.UTC(), now.In(time.FixedZone("X", 3600)), RequireSameTimeLocation())
+
+	result := CompareWith(now.UTC(), now.In(time.FixedZone("X", 3600)), RequireSameTimeLocation())
+	if len(result.Differences) != 1 || result.Differences[0].Note != "location UTC vs X" {
+		t.Errorf("Unexpected result %v", result)
+	}
+}
+
+func TestTruncateTimes(t *testing.T) {
+	base := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assertEquivalentWith(t, base.Add(100*time.Millisecond), base.Add(900*time.Millisecond), TruncateTimes(time.Second))
+	assertNotEquivalentWith(t, base.Add(900*time.Millisecond), base.Add(1100*time.Millisecond), TruncateTimes(time.Second))
+	assertNotEquivalentWith(t, base, base.Add(time.Millisecond))
+}
+
+func TestTimeTolerance(t *testing.T) {
+	base := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assertEquivalentWith(t, base, base.Add(time.Second), TimeTolerance(time.Second))
+	assertEquivalentWith(t, base.Add(time.Second), base, TimeTolerance(time.Second))
+	assertNotEquivalentWith(t, base, base.Add(time.Second+1), TimeTolerance(time.Second))
+	assertEquivalentWith(t, base.Add(900*time.Millisecond), base.Add(1100*time.Millisecond), TimeTolerance(time.Second), TruncateTimes(time.Second))
+
+	result := CompareWith(base, base.Add(2*time.Second), TimeTolerance(time.Second))
+	if len(result.Differences) != 1 || result.Differences[0].Note != "outside tolerance of 1s" {
+		t.Errorf("Unexpected result %v", result)
+	}
+}
+
+func TestStructAndMapOptions(t *testing.T) {
+	type Item struct {
+		Name  string
+		Count int
+		Sizes []int `json:"sizes"`
+	}
+	assertEquivalentWith(t, Item{Name: "a"}, map[string]interface{}{"Name": "a", "Other": 0}, MissingMapKeysAsZero())
+	assertNotEquivalentWith(t, Item{Name: "a"}, map[string]interface{}{"Name": "a", "Other": 1}, MissingMapKeysAsZero())
+	assertNotEquivalentWith(t, Item{Name: "a", Count: 1}, map[string]interface{}{"Name": "a"}, MissingMapKeysAsZero())
+
+	item := Item{Name: "a", Count: 1, Sizes: []int{1, 2}}
+	asMap := map[string]interface{}{"Name": "a", "Count": 1, "sizes": []interface{}{2.0, 1.0}}
+	assertNotEquivalentWith(t, item, asMap)
+	assertEquivalentWith(t, item, asMap, UnorderedSlicesAt(".Sizes"))
+	assertNotEquivalentWith(t, item, map[string]interface{}{"Name": "a", "Count": int8(1), "sizes": []int{1, 2}}, RequireExactTypes(NumericKinds))
+}
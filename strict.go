@@ -0,0 +1,51 @@
+package equivalence
+
+import (
+	"reflect"
+)
+
+// KindGroup selects groups of kinds for RequireExactTypes. Groups can be
+// combined using `|`.
+type KindGroup int
+
+const (
+	// Integers, floats, complex numbers, big.Int, big.Float and big.Rat
+	NumericKinds KindGroup = 1 << iota
+	// Strings
+	StringKinds
+	// Arrays, slices and maps
+	ContainerKinds
+	// Structs (other than those counted as numeric)
+	StructKinds
+	// Everything else: bools, channels, functions, and pointer values
+	OtherKinds
+
+	AllKinds = NumericKinds | StringKinds | ContainerKinds | StructKinds | OtherKinds
+)
+
+func getKindGroup(v reflect.Value) KindGroup {
+	if getNumericClass(v) != numericClassNone {
+		return NumericKinds
+	}
+	switch v.Kind() {
+	case reflect.Complex64, reflect.Complex128:
+		return NumericKinds
+	case reflect.String:
+		return StringKinds
+	case reflect.Array, reflect.Slice, reflect.Map:
+		return ContainerKinds
+	case reflect.Struct:
+		return StructKinds
+	}
+	return OtherKinds
+}
+
+// Returns false if a and b are of different types, and either of them is in a
+// kind group that requires exact types.
+func (_this *comparator) areTypesStrictlyCompatible(a, b reflect.Value) bool {
+	strictGroups := _this.options.exactTypeKinds
+	if strictGroups == 0 || a.Type() == b.Type() {
+		return true
+	}
+	return (getKindGroup(a)|getKindGroup(b))&strictGroups == 0
+}
@@ -0,0 +1,81 @@
+package equivalence
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Struct tags with this key control how a field is compared:
+//
+//	`equivalence:"-"`         Don't compare this field.
+//	`equivalence:"name=foo"`  Match this field to a field named "foo" in the
+//	                          other struct (by Go name or by its own
+//	                          name=foo tag).
+//	`equivalence:"unordered"` Compare this array or slice field as a
+//	                          multiset (see UnorderedSlices).
+//
+// Entries can be combined with commas: `equivalence:"name=foo,unordered"`
+const structTagKey = "equivalence"
+
+type structField struct {
+	// The field's position in the struct
+	index int
+	// The field's Go name
+	name string
+	// The name used to match this field to a field in another struct
+	matchName string
+	// If true, this field is not compared
+	isIgnored bool
+	// If true, this field's array or slice is compared as a multiset
+	isUnordered bool
+}
+
+// Cache of reflect.Type -> []structField
+var structFieldsCache sync.Map
+
+func getStructFields(t reflect.Type) []structField {
+	if fields, ok := structFieldsCache.Load(t); ok {
+		return fields.([]structField)
+	}
+
+	fields := make([]structField, t.NumField())
+	for i := range fields {
+		field := t.Field(i)
+		fields[i] = structField{
+			index:     i,
+			name:      field.Name,
+			matchName: field.Name,
+		}
+		if tag, ok := field.Tag.Lookup(structTagKey); ok {
+			applyStructTag(&fields[i], tag)
+		}
+	}
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+func applyStructTag(field *structField, tag string) {
+	for _, entry := range strings.Split(tag, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "-":
+			field.isIgnored = true
+		case entry == "unordered":
+			field.isUnordered = true
+		case strings.HasPrefix(entry, "name="):
+			field.matchName = strings.TrimPrefix(entry, "name=")
+		}
+	}
+}
+
+// Returns the field in fields that matches name, or nil if not found.
+// Ignored fields never match.
+func findStructField(fields []structField, matchName string) *structField {
+	for i := range fields {
+		if fields[i].matchName == matchName && !fields[i].isIgnored {
+			return &fields[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package equivalence
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Struct tags that encoders use to name a field's map key.
+var mapKeyTagKeys = []string{"json", "yaml"}
+
+// A struct field as it would appear in a map that the struct was encoded to.
+type mapField struct {
+	// The field's position, through any embedded structs
+	index []int
+	// The field's Go name
+	name string
+	// The map keys that this field can be stored under, in order of preference
+	keys []string
+	// If true, the field's key comes from a json or yaml tag
+	isTagged bool
+	// If true, encoders leave this field out of the map when it's empty
+	omitEmpty bool
+	// If true, this field's array or slice is compared as a multiset
+	isUnordered bool
+}
+
+// Cache of reflect.Type -> []mapField
+var mapFieldsCache sync.Map
+
+// Get the fields of struct type t, as they would appear in a map encoded by
+// encoding/json (which YAML encoders also follow closely enough). Unexported
+// and ignored fields are left out, and the fields of embedded structs (or
+// pointers to structs) with no key name are promoted. Where several fields
+// have the same key, the shallowest one wins. If there are several at the
+// same depth, a tagged one wins if there's only one, otherwise none of them
+// are used.
+func getMapFields(t reflect.Type) []mapField {
+	if fields, ok := mapFieldsCache.Load(t); ok {
+		return fields.([]mapField)
+	}
+
+	type embeddedStruct struct {
+		index []int
+		t     reflect.Type
+	}
+	var fields []mapField
+	isKeyTaken := make(map[string]bool)
+	isTypeVisited := make(map[reflect.Type]bool)
+	next := []embeddedStruct{{t: t}}
+	for len(next) > 0 {
+		current := next
+		next = nil
+		var level []mapField
+		for _, embedded := range current {
+			if isTypeVisited[embedded.t] {
+				continue
+			}
+			isTypeVisited[embedded.t] = true
+			for _, field := range getStructFields(embedded.t) {
+				if field.isIgnored {
+					continue
+				}
+				structField := embedded.t.Field(field.index)
+				fieldType := structField.Type
+				if fieldType.Kind() == reflect.Ptr {
+					fieldType = fieldType.Elem()
+				}
+				if structField.PkgPath != "" && (!structField.Anonymous || fieldType.Kind() != reflect.Struct) {
+					// Unexported, and not an embedded struct that could have
+					// exported fields.
+					continue
+				}
+				mf := mapField{
+					index:       append(append([]int(nil), embedded.index...), field.index),
+					name:        field.name,
+					isUnordered: field.isUnordered,
+				}
+				keys, isOmitted := applyMapKeyTags(&mf, structField.Tag)
+				if isOmitted {
+					continue
+				}
+				if structField.Anonymous && len(keys) == 0 && fieldType.Kind() == reflect.Struct {
+					next = append(next, embeddedStruct{index: mf.index, t: fieldType})
+					continue
+				}
+				mf.isTagged = len(keys) > 0
+				mf.keys = append(keys, field.name)
+				if field.matchName != field.name {
+					mf.keys = append(mf.keys, field.matchName)
+				}
+				level = append(level, mf)
+			}
+		}
+
+		levelCountByKey := make(map[string]int)
+		taggedCountByKey := make(map[string]int)
+		for _, field := range level {
+			levelCountByKey[field.keys[0]]++
+			if field.isTagged {
+				taggedCountByKey[field.keys[0]]++
+			}
+		}
+		for _, field := range level {
+			key := field.keys[0]
+			if isKeyTaken[key] {
+				continue
+			}
+			if levelCountByKey[key] > 1 && (!field.isTagged || taggedCountByKey[key] != 1) {
+				continue
+			}
+			fields = append(fields, field)
+		}
+		for key := range levelCountByKey {
+			isKeyTaken[key] = true
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return isIndexBefore(fields[i].index, fields[j].index)
+	})
+	mapFieldsCache.Store(t, fields)
+	return fields
+}
+
+// Returns true if the field at index a comes before the field at index b.
+func isIndexBefore(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// Get the value of the field at index in s, or false if it's inside an
+// embedded struct pointer that is nil (in which case encoders leave it out).
+func getMapFieldValue(s reflect.Value, index []int) (v reflect.Value, ok bool) {
+	v = s
+	for i, fieldIndex := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(fieldIndex)
+	}
+	return v, true
+}
+
+// Apply a field's json and yaml tags, returning the key names they give. If
+// every one of these tags is "-", the field is omitted from maps.
+func applyMapKeyTags(field *mapField, tag reflect.StructTag) (keys []string, isOmitted bool) {
+	tagCount := 0
+	omittedCount := 0
+	for _, tagKey := range mapKeyTagKeys {
+		value, ok := tag.Lookup(tagKey)
+		if !ok {
+			continue
+		}
+		tagCount++
+		if value == "-" {
+			omittedCount++
+			continue
+		}
+		entries := strings.Split(value, ",")
+		if entries[0] != "" {
+			keys = append(keys, entries[0])
+		}
+		for _, entry := range entries[1:] {
+			if entry == "omitempty" {
+				field.omitEmpty = true
+			}
+		}
+	}
+	return keys, tagCount > 0 && omittedCount == tagCount
+}
+
+// Returns true if v is a map that a struct can be compared against (one with
+// string or interface keys).
+func isStructMap(v reflect.Value) bool {
+	if v.Kind() != reflect.Map {
+		return false
+	}
+	keyType := v.Type().Key()
+	return keyType.Kind() == reflect.String || keyType == emptyInterfaceType
+}
+
+// Returns true if v is a struct that can be compared against a map as a set of
+// fields. Numbers, structs with their own idea of equivalence (comparers and
+// equality methods), and structs with no fields that would appear in a map are
+// not.
+func (_this *comparator) isMapFieldStruct(v reflect.Value) bool {
+	if v.Kind() != reflect.Struct || getNumericClass(v) != numericClassNone || _this.hasCustomEquivalence(v) {
+		return false
+	}
+	return len(getMapFields(v.Type())) > 0
+}
+
+// Returns true if a and b are a struct and a map that it can be compared
+// against, in either order.
+func (_this *comparator) isStructAndMap(a, b reflect.Value) bool {
+	return (isStructMap(b) && _this.isMapFieldStruct(a)) ||
+		(isStructMap(a) && _this.isMapFieldStruct(b))
+}
+
+// Find the key in m that field is stored under.
+func findMapFieldKey(m reflect.Value, field *mapField) (key reflect.Value, ok bool) {
+	keyType := m.Type().Key()
+	for _, name := range field.keys {
+		key = reflect.ValueOf(name)
+		if keyType.Kind() == reflect.String {
+			key = key.Convert(keyType)
+		}
+		if m.MapIndex(key).IsValid() {
+			return key, true
+		}
+	}
+	return key, false
+}
+
+// Returns true if a value that only exists on one side of a struct vs map
+// comparison can be skipped, because encoders would leave it out (omitempty)
+// or because of MissingMapKeysAsZero.
+func (_this *comparator) canSkipMissingMapField(v reflect.Value, omitEmpty bool) bool {
+	if !omitEmpty && !_this.options.missingMapKeysAsZero {
+		return false
+	}
+	concrete, _ := drillDown(v)
+	if !concrete.IsValid() {
+		return true
+	}
+	return _this.areElementsEquivalent(v, reflect.Zero(concrete.Type()))
+}
+
+// Compare a struct against a map in either order, matching each field to the
+// map key named by its json or yaml tag, or by its Go name.
+func (_this *comparator) areStructAndMapEquivalent(a, b reflect.Value) bool {
+	s, m := a, b
+	structIsA := a.Kind() == reflect.Struct
+	if !structIsA {
+		s, m = b, a
+	}
+	// Put a struct value and a map value back in a, b order.
+	order := func(sv, mv reflect.Value) (reflect.Value, reflect.Value) {
+		if structIsA {
+			return sv, mv
+		}
+		return mv, sv
+	}
+	structSide, mapSide := SideA, SideB
+	if !structIsA {
+		structSide, mapSide = SideB, SideA
+	}
+
+	isEquivalent := true
+	usedKeys := make(map[string]bool)
+	for i, field := range getMapFields(s.Type()) {
+		sv, ok := getMapFieldValue(s, field.index)
+		if !ok {
+			continue
+		}
+		_this.pushField(a, b, field.name, i)
+		if key, ok := findMapFieldKey(m, &field); !ok {
+			if !_this.canSkipMissingMapField(sv, field.omitEmpty) {
+				reason := ReasonMissingField
+				if !structIsA {
+					reason = ReasonExtraField
+				}
+				isEquivalent = _this.failMissing(reason, "", sv, mapSide)
+			}
+		} else {
+			usedKeys[key.String()] = true
+			_this.isUnorderedField = field.isUnordered
+			if !_this.areObjectsEquivalent(order(sv, m.MapIndex(key))) {
+				isEquivalent = false
+			}
+		}
+		_this.popPath()
+		if !isEquivalent && !_this.isCollecting() {
+			return false
+		}
+	}
+
+	for _, key := range _this.getMapKeys(m) {
+		concreteKey := unwrapInterface(key)
+		if concreteKey.Kind() == reflect.String && usedKeys[concreteKey.String()] {
+			continue
+		}
+		mv := m.MapIndex(key)
+		if _this.canSkipMissingMapField(mv, false) {
+			continue
+		}
+		_this.pushMapKey(a, b, key)
+		isEquivalent = _this.failMissing(ReasonMissingKey, "", unwrapInterface(mv), structSide)
+		_this.popPath()
+		if !_this.isCollecting() {
+			return false
+		}
+	}
+	return isEquivalent
+}
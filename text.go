@@ -0,0 +1,96 @@
+package equivalence
+
+import (
+	"bytes"
+	"reflect"
+)
+
+type textKind int
+
+const (
+	textKindNone textKind = iota
+	textKindString
+	textKindBytes
+	textKindRunes
+)
+
+// Get the kind of text that v holds, if any: a string, a byte slice or array,
+// or a rune slice.
+func getTextKind(v reflect.Value) textKind {
+	switch v.Kind() {
+	case reflect.String:
+		return textKindString
+	case reflect.Slice:
+		switch v.Type().Elem().Kind() {
+		case reflect.Uint8:
+			return textKindBytes
+		case reflect.Int32:
+			return textKindRunes
+		}
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return textKindBytes
+		}
+	}
+	return textKindNone
+}
+
+// Get the text held by v, which must be of a text kind. Trailing zero bytes in
+// byte arrays are treated as padding.
+func getText(v reflect.Value) string {
+	switch getTextKind(v) {
+	case textKindString:
+		return v.String()
+	case textKindBytes:
+		if v.Kind() == reflect.Array {
+			data := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(data), v)
+			return string(bytes.TrimRight(data, "\x00"))
+		}
+		return string(v.Bytes())
+	case textKindRunes:
+		runes := make([]rune, v.Len())
+		for i := range runes {
+			runes[i] = rune(v.Index(i).Int())
+		}
+		return string(runes)
+	}
+	return ""
+}
+
+// Returns true if a and b should be compared as text (see
+// CompareBytesAndRunesAsText): if they hold different kinds of text, or if
+// either is a byte array (which might be padded).
+func (_this *comparator) shouldCompareAsText(a, b reflect.Value) bool {
+	if !_this.options.compareBytesAndRunesAsText {
+		return false
+	}
+	aKind := getTextKind(a)
+	bKind := getTextKind(b)
+	if aKind == textKindNone || bKind == textKindNone {
+		return false
+	}
+	return aKind != bKind || a.Kind() == reflect.Array || b.Kind() == reflect.Array
+}
+
+// Compare a and b as text. Values that aren't strings are also equivalent if
+// their elements are, since comparing as text is meant to make more values
+// equivalent, not fewer.
+func (_this *comparator) areTextsEquivalent(a, b reflect.Value, isUnorderedField bool) bool {
+	if _this.options.distinguishNilFromEmpty && isNilContainer(a) != isNilContainer(b) {
+		return _this.fail(ReasonValueDiffers, a, b)
+	}
+	if getText(a) == getText(b) {
+		return true
+	}
+	if a.Kind() != reflect.String && b.Kind() != reflect.String {
+		compareElements := _this.areArraysOrSlicesEquivalent
+		if _this.isUnorderedAtCurrentPath(isUnorderedField) {
+			compareElements = _this.areArraysOrSlicesEquivalentUnordered
+		}
+		if _this.compareInIsolation(func() bool { return compareElements(a, b) }) {
+			return true
+		}
+	}
+	return _this.fail(ReasonValueDiffers, a, b)
+}
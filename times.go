@@ -0,0 +1,147 @@
+package equivalence
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// The layout of time.Time's internal fields (see the time package).
+const (
+	timeHasMonotonic = 1 << 63
+	timeNsecMask     = 1<<30 - 1
+	timeNsecShift    = 30
+	secondsPerDay    = 24 * 60 * 60
+	// Seconds from January 1 of year 1 to January 1, 1885 (where the wall
+	// seconds of a time with a monotonic reading count from), and to the Unix
+	// epoch.
+	timeWallToInternal = (1884*365 + 1884/4 - 1884/100 + 1884/400) * secondsPerDay
+	timeUnixToInternal = (1969*365 + 1969/4 - 1969/100 + 1969/400) * secondsPerDay
+)
+
+// Returns true if t has the internal fields that readTimeFields expects.
+func hasTimeFields(t reflect.Type) bool {
+	return t.NumField() == 3 &&
+		t.Field(0).Name == "wall" && t.Field(0).Type.Kind() == reflect.Uint64 &&
+		t.Field(1).Name == "ext" && t.Field(1).Type.Kind() == reflect.Int64 &&
+		t.Field(2).Name == "loc" && t.Field(2).Type.Kind() == reflect.Ptr &&
+		t.Field(2).Type.Elem().NumField() > 0 && t.Field(2).Type.Elem().Field(0).Type.Kind() == reflect.String
+}
+
+var canReadTimeFields = hasTimeFields(timeType) && canDecodeTimeFields()
+
+// Returns true if readTimeFields decodes some known times correctly, with and
+// without a monotonic clock reading. The fields are private to the time
+// package, so their encoding could change without the field types changing.
+func canDecodeTimeFields() (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+	samples := []time.Time{
+		{},
+		time.Date(2001, 2, 3, 4, 5, 6, 7, time.UTC),
+		time.Date(1800, 12, 31, 23, 59, 59, 999999999, time.FixedZone("X", -3600)),
+		time.Now(),
+	}
+	for _, expected := range samples {
+		holder := struct{ t time.Time }{expected}
+		actual, location := readTimeFields(reflect.ValueOf(holder).Field(0))
+		if !actual.Equal(expected) || location != expected.Location().String() {
+			return false
+		}
+	}
+	return true
+}
+
+// Rebuild the instant and location name of the time.Time in v from its
+// internal fields, which reflect can read even when v was taken from an
+// unexported field. The time is returned in UTC.
+func readTimeFields(v reflect.Value) (t time.Time, location string) {
+	wall := v.Field(0).Uint()
+	ext := v.Field(1).Int()
+	nsec := int64(wall & timeNsecMask)
+	seconds := ext
+	if wall&timeHasMonotonic != 0 {
+		seconds = timeWallToInternal + int64(wall<<1>>(timeNsecShift+1))
+	}
+	t = time.Unix(seconds-timeUnixToInternal, nsec).UTC()
+
+	location = "UTC"
+	if loc := v.Field(2); loc.Pointer() == reflect.ValueOf(time.Local).Pointer() {
+		// Local's name is only filled in once it's first used.
+		location = "Local"
+	} else if !loc.IsNil() {
+		location = loc.Elem().Field(0).String()
+	}
+	return t, location
+}
+
+// Get the time.Time in v, and the name of its location.
+func getTime(v reflect.Value) (t time.Time, location string, ok bool) {
+	if v.Type() != timeType {
+		return t, "", false
+	}
+	if v.CanInterface() {
+		t = v.Interface().(time.Time)
+		return t, t.Location().String(), true
+	}
+	if !canReadTimeFields {
+		return t, "", false
+	}
+	t, location = readTimeFields(v)
+	return t, location, true
+}
+
+// Get a readable copy of the time.Time in v (see getReadableCopy), in its
+// own location if it can be found.
+func getReadableTime(v reflect.Value) (readable reflect.Value, ok bool) {
+	t, location, ok := getTime(v)
+	if !ok {
+		return readable, false
+	}
+	if location == "Local" {
+		t = t.Local()
+	} else if loc, err := time.LoadLocation(location); err == nil {
+		t = t.In(loc)
+	}
+	return reflect.ValueOf(t), true
+}
+
+// Compare two times as instants (ignoring any monotonic clock reading), using
+// the configured location, truncation, and tolerance rules.
+func (_this *comparator) areTimesEquivalent(a, b reflect.Value) bool {
+	aTime, aLocation, aOK := getTime(a)
+	bTime, bLocation, bOK := getTime(b)
+	if !aOK || !bOK {
+		if a.Type() == timeType && b.Type() == timeType {
+			return _this.areStructsEquivalent(a, b)
+		}
+		return _this.fail(ReasonTypeMismatch, a, b)
+	}
+
+	opts := &_this.options
+	if opts.requireSameTimeLocation && aLocation != bLocation {
+		return _this.failWithNote(ReasonValueDiffers,
+			fmt.Sprintf("location %v vs %v", aLocation, bLocation), a, b)
+	}
+	if opts.timePrecision > 0 {
+		aTime = aTime.Truncate(opts.timePrecision)
+		bTime = bTime.Truncate(opts.timePrecision)
+	}
+	if aTime.Equal(bTime) {
+		return true
+	}
+	if opts.timeTolerance > 0 {
+		difference := aTime.Sub(bTime)
+		if difference >= -opts.timeTolerance && difference <= opts.timeTolerance {
+			return true
+		}
+		return _this.failWithNote(ReasonValueDiffers,
+			fmt.Sprintf("outside tolerance of %v", opts.timeTolerance), a, b)
+	}
+	return _this.fail(ReasonValueDiffers, a, b)
+}
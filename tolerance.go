@@ -0,0 +1,141 @@
+package equivalence
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+)
+
+// Tolerances for comparing floating point values. A zero tolerance is
+// disabled. Two values are considered equivalent if they fall within any
+// enabled tolerance.
+type floatTolerance struct {
+	absolute float64
+	relative float64
+	ulps     uint64
+}
+
+func (_this *floatTolerance) isEnabled() bool {
+	return _this.absolute > 0 || _this.relative > 0 || _this.ulps > 0
+}
+
+func (_this *floatTolerance) String() string {
+	buff := bytes.Buffer{}
+	appendTolerance := func(description string) {
+		if buff.Len() > 0 {
+			buff.WriteString(" or ")
+		}
+		buff.WriteString(description)
+	}
+	if _this.absolute > 0 {
+		appendTolerance(fmt.Sprintf("absolute tolerance %v", _this.absolute))
+	}
+	if _this.relative > 0 {
+		appendTolerance(fmt.Sprintf("relative tolerance %v", _this.relative))
+	}
+	if _this.ulps > 0 {
+		appendTolerance(fmt.Sprintf("tolerance of %v ULPs", _this.ulps))
+	}
+	return buff.String()
+}
+
+// Returns true if numeric values a and b are within tolerance of each other.
+// NaN and infinite values are never within tolerance.
+func (_this *floatTolerance) isWithinTolerance(a, b reflect.Value) bool {
+	fa, ok := numericToFloat64(a)
+	if !ok {
+		return false
+	}
+	fb, ok := numericToFloat64(b)
+	if !ok {
+		return false
+	}
+
+	difference := math.Abs(fa - fb)
+	if _this.absolute > 0 && difference <= _this.absolute {
+		return true
+	}
+	if _this.relative > 0 && difference <= _this.relative*math.Max(math.Abs(fa), math.Abs(fb)) {
+		return true
+	}
+	if _this.ulps > 0 {
+		// ULPs are measured in the least precise float type being compared.
+		if a.Kind() == reflect.Float32 || b.Kind() == reflect.Float32 {
+			return float32ULPDistance(float32(fa), float32(fb)) <= _this.ulps
+		}
+		return float64ULPDistance(fa, fb) <= _this.ulps
+	}
+	return false
+}
+
+// Convert a numeric value to the nearest float64. Returns false if the value
+// is NaN or infinite.
+func numericToFloat64(v reflect.Value) (float64, bool) {
+	var f float64
+	switch getNumericClass(v) {
+	case numericClassInt:
+		f = float64(v.Int())
+	case numericClassUint:
+		f = float64(v.Uint())
+	case numericClassFloat:
+		f = v.Float()
+	case numericClassBigInt:
+		bi := v.Interface().(big.Int)
+		f, _ = new(big.Float).SetInt(&bi).Float64()
+	case numericClassBigFloat:
+		bf := v.Interface().(big.Float)
+		f, _ = bf.Float64()
+	case numericClassBigRat:
+		br := v.Interface().(big.Rat)
+		f, _ = br.Float64()
+	case numericClassDecimal:
+		r, bf := getDecimalValue(v)
+		if bf != nil {
+			f, _ = bf.Float64()
+		} else {
+			f, _ = r.Float64()
+		}
+	default:
+		return 0, false
+	}
+	return f, !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// Map float bits onto a range of integers that has the same ordering as the
+// floats they represent, so that the distance between two floats in ULPs is
+// the difference between their mapped integers.
+func orderedFloat64Bits(f float64) int64 {
+	bits := int64(math.Float64bits(f))
+	if bits < 0 {
+		bits = math.MinInt64 - bits
+	}
+	return bits
+}
+
+func orderedFloat32Bits(f float32) int32 {
+	bits := int32(math.Float32bits(f))
+	if bits < 0 {
+		bits = math.MinInt32 - bits
+	}
+	return bits
+}
+
+func float64ULPDistance(a, b float64) uint64 {
+	ia := orderedFloat64Bits(a)
+	ib := orderedFloat64Bits(b)
+	if ia > ib {
+		return uint64(ia) - uint64(ib)
+	}
+	return uint64(ib) - uint64(ia)
+}
+
+func float32ULPDistance(a, b float32) uint64 {
+	ia := int64(orderedFloat32Bits(a))
+	ib := int64(orderedFloat32Bits(b))
+	if ia > ib {
+		return uint64(ia - ib)
+	}
+	return uint64(ib - ia)
+}
@@ -0,0 +1,217 @@
+package equivalence
+
+import (
+	"reflect"
+)
+
+// Returns true if the array or slice at the current path should be compared
+// as a multiset.
+func (_this *comparator) isUnorderedAtCurrentPath(isUnorderedField bool) bool {
+	if _this.options.unorderedSlices || isUnorderedField {
+		return true
+	}
+	return len(_this.options.unorderedPaths) > 0 && _this.options.unorderedPaths[_this.path.String()]
+}
+
+// Test if two elements are equivalent without affecting the path or the
+// recorded differences of this comparator, or the objects paired up so far (see
+// PreserveAliasing).
+//
+// The pairs being compared are shared with the rest of the walk, so that a
+// cycle back to one of them is still caught.
+func (_this *comparator) areElementsEquivalent(a, b reflect.Value) bool {
+	return _this.compareInIsolation(func() bool {
+		return _this.areObjectsEquivalent(a, b)
+	})
+}
+
+// Run compare in isolation (see areElementsEquivalent).
+func (_this *comparator) compareInIsolation(compare func() bool) (isEquivalent bool) {
+	path := _this.path
+	result := _this.result
+	aliases := _this.aliases
+	panicA, panicB := _this.panicA, _this.panicB
+	isIsolated := _this.isIsolated
+	_this.result = nil
+	_this.aliases = aliasTracker{}
+	_this.isIsolated = true
+	defer func() {
+		if r := recover(); r != nil {
+			isEquivalent = false
+		}
+		_this.path = path
+		_this.result = result
+		_this.aliases = aliases
+		_this.panicA, _this.panicB = panicA, panicB
+		_this.isIsolated = isIsolated
+		_this.isUnorderedField = false
+	}()
+	return compare()
+}
+
+// Compare arrays or slices as multisets, where every element in a must be
+// equivalent to a different element in b, regardless of position.
+func (_this *comparator) areArraysOrSlicesEquivalentUnordered(a, b reflect.Value) bool {
+	isEquivalent := true
+	if a.Len() != b.Len() {
+		isEquivalent = _this.fail(ReasonLengthMismatch, a, b)
+		if !_this.isCollecting() {
+			return false
+		}
+	}
+
+	aMatches, bMatches := _this.matchElements(a, b)
+	for i, match := range aMatches {
+		if match < 0 {
+			_this.pushIndex(a, b, i)
+			isEquivalent = _this.failMissing(ReasonUnmatchedElement, "", unwrapInterface(a.Index(i)), SideB)
+			_this.popPath()
+			if !_this.isCollecting() {
+				return false
+			}
+		}
+	}
+	for i, match := range bMatches {
+		if match < 0 {
+			_this.pushIndexB(a, b, i)
+			isEquivalent = _this.failMissing(ReasonUnmatchedElement, "", unwrapInterface(b.Index(i)), SideA)
+			_this.popPath()
+		}
+	}
+	return isEquivalent
+}
+
+// Pair each element of a with a distinct equivalent element of b, finding the
+// largest possible number of pairs.
+//
+// Elements with a canonical form (see getCanonicalKey) are only equivalent to
+// elements with the same canonical form, so if every element has one, they are
+// simply paired up within each group of the same form. Otherwise this falls
+// back to matchIndexes, which only has to fully compare pairs where at least
+// one element has no canonical form.
+//
+// Returns, for each element of a, the index of its match in b (and vice
+// versa), or -1 if it has no match.
+func (_this *comparator) matchElements(a, b reflect.Value) (aMatches, bMatches []int) {
+	aKeys, aHasAllKeys := _this.getElementKeys(a)
+	bKeys, bHasAllKeys := _this.getElementKeys(b)
+	if aHasAllKeys && bHasAllKeys {
+		return matchKeys(aKeys, bKeys)
+	}
+
+	return matchIndexes(a.Len(), b.Len(), func(aIndex, bIndex int) bool {
+		if aKeys != nil && aKeys[aIndex] != "" && bKeys[bIndex] != "" {
+			return aKeys[aIndex] == bKeys[bIndex]
+		}
+		_this.pushIndex(a, b, aIndex)
+		defer _this.popPath()
+		return _this.areElementsEquivalent(a.Index(aIndex), b.Index(bIndex))
+	})
+}
+
+// Get the canonical key of every element of v, or "" for elements that have
+// none. keys will be nil if canonical keys can't be used to match elements
+// under this comparator's options: they ignore float tolerances, aliasing, and
+// unordered paths.
+func (_this *comparator) getElementKeys(v reflect.Value) (keys []string, hasAllKeys bool) {
+	opts := &_this.options
+	if opts.floatTolerance.isEnabled() || opts.preserveAliasing || len(opts.unorderedPaths) > 0 {
+		return nil, false
+	}
+	keys = make([]string, v.Len())
+	hasAllKeys = true
+	for i := range keys {
+		key, ok := _this.getCanonicalKey(v.Index(i))
+		if !ok {
+			hasAllKeys = false
+			continue
+		}
+		// Canonical keys are never empty.
+		keys[i] = key
+	}
+	return keys, hasAllKeys
+}
+
+// Pair each of aKeys with a distinct identical one of bKeys, in order of
+// position so that already ordered data pairs up by position.
+//
+// Returns, for each index into aKeys, the index of its match in bKeys (and
+// vice versa), or -1 if it has no match.
+func matchKeys(aKeys, bKeys []string) (aMatches, bMatches []int) {
+	aMatches = make([]int, len(aKeys))
+	bMatches = make([]int, len(bKeys))
+	for i := range bMatches {
+		bMatches[i] = -1
+	}
+
+	unmatched := make(map[string][]int, len(bKeys))
+	for bIndex, key := range bKeys {
+		unmatched[key] = append(unmatched[key], bIndex)
+	}
+	for aIndex, key := range aKeys {
+		aMatches[aIndex] = -1
+		if candidates := unmatched[key]; len(candidates) > 0 {
+			aMatches[aIndex] = candidates[0]
+			bMatches[candidates[0]] = aIndex
+			unmatched[key] = candidates[1:]
+		}
+	}
+	return
+}
+
+// Pair each of aLen things with a distinct equivalent one of bLen things,
+// finding the largest possible number of pairs. Since equivalence isn't
+// necessarily transitive (for example with float tolerances), this uses a
+// maximum bipartite matching rather than taking the first equivalent one
+// found.
+//
+// Returns, for each index into a, the index of its match in b (and vice
+// versa), or -1 if it has no match.
+func matchIndexes(aLen, bLen int, isEquivalent func(aIndex, bIndex int) bool) (aMatches, bMatches []int) {
+	aMatches = make([]int, aLen)
+	bMatches = make([]int, bLen)
+	for i := range aMatches {
+		aMatches[i] = -1
+	}
+	for i := range bMatches {
+		bMatches[i] = -1
+	}
+
+	type elementPair struct{ a, b int }
+	knownEquivalence := make(map[elementPair]bool)
+	areEquivalent := func(aIndex, bIndex int) bool {
+		pair := elementPair{aIndex, bIndex}
+		result, ok := knownEquivalence[pair]
+		if !ok {
+			result = isEquivalent(aIndex, bIndex)
+			knownEquivalence[pair] = result
+		}
+		return result
+	}
+
+	var visited []bool
+	var tryMatch func(aIndex int) bool
+	tryMatch = func(aIndex int) bool {
+		// Start at the same position so that already ordered data matches
+		// quickly.
+		for offset := 0; offset < bLen; offset++ {
+			bIndex := (aIndex + offset) % bLen
+			if visited[bIndex] || !areEquivalent(aIndex, bIndex) {
+				continue
+			}
+			visited[bIndex] = true
+			if bMatches[bIndex] < 0 || tryMatch(bMatches[bIndex]) {
+				bMatches[bIndex] = aIndex
+				aMatches[aIndex] = bIndex
+				return true
+			}
+		}
+		return false
+	}
+
+	for aIndex := 0; aIndex < aLen; aIndex++ {
+		visited = make([]bool, bLen)
+		tryMatch(aIndex)
+	}
+	return
+}
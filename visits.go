@@ -0,0 +1,237 @@
+package equivalence
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// The identity of an object that can be referred to from several places.
+type identity struct {
+	address uintptr
+	t       reflect.Type
+	// Slices can share the same data pointer with different lengths.
+	length int
+}
+
+// A visit is a comparison between a specific object in a and a specific object
+// in b. Keying on the pair (like reflect.DeepEqual does) rather than on each
+// side separately means that an object shared within one side is still
+// compared against everything it's paired with on the other side.
+type visit struct {
+	a identity
+	b identity
+}
+
+// A pair that was compared under particular rules. The same pair might need
+// comparing again somewhere else under different rules (such as an unordered
+// field or path).
+type visitContext struct {
+	visit       visit
+	isUnordered bool
+	// Only set when unordered paths are configured, since the rules for
+	// everything below depend on it.
+	path string
+}
+
+type visitResult struct {
+	isEquivalent bool
+	// True if the pair was compared in isolation (see areElementsEquivalent),
+	// which means that its differences weren't reported and its aliasing
+	// wasn't recorded.
+	isIsolated bool
+}
+
+// Returns true if a pair that was already compared must be compared again to
+// report what the earlier comparison didn't.
+func (_this *comparator) mustCompareAgain(result visitResult) bool {
+	if !result.isIsolated || _this.isIsolated {
+		return false
+	}
+	return _this.options.preserveAliasing || (!result.isEquivalent && _this.isCollecting())
+}
+
+// Get the identity of the object that v refers to or is stored in.
+func getIdentity(v reflect.Value) (id identity, ok bool) {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			return id, false
+		}
+		return identity{address: v.Pointer(), t: v.Type()}, true
+	case reflect.Slice:
+		if v.IsNil() {
+			return id, false
+		}
+		return identity{address: v.Pointer(), t: v.Type(), length: v.Len()}, true
+	}
+	if v.CanAddr() {
+		return identity{address: v.UnsafeAddr(), t: v.Type()}, true
+	}
+	return id, false
+}
+
+// Get the visit that comparing a with b makes.
+//
+// Only objects that can be part of a cycle (those reached through a pointer,
+// and maps and slices) are tracked, and only if both sides have an identity.
+// Anything else is a copy, and the cycle will be caught further down where
+// its pointer, map, or slice is.
+func getVisit(a, b reflect.Value, wasPointer bool) (key visit, ok bool) {
+	if !a.IsValid() || !b.IsValid() {
+		return key, false
+	}
+	if !wasPointer && !isReferenceKind(a.Kind()) && !isReferenceKind(b.Kind()) {
+		return key, false
+	}
+	aID, ok := getIdentity(a)
+	if !ok {
+		return key, false
+	}
+	bID, ok := getIdentity(b)
+	if !ok {
+		return key, false
+	}
+	return visit{a: aID, b: bID}, true
+}
+
+// Compare a pair that can be part of a cycle, or shared by several parts of
+// the compared objects.
+//
+// Like reflect.DeepEqual, a pair that is reached again while it's still being
+// compared (a cycle) is assumed to be equivalent, since its comparison further
+// up will decide the result. Completed pairs are remembered, so that shared
+// objects are only compared once. A pair that turned out to be equivalent is
+// only remembered if it didn't rely on such an assumption about a pair further
+// up, since that pair might still turn out not to be.
+func (_this *comparator) areVisitedValuesEquivalent(key visit, a, b reflect.Value, isUnorderedField bool) bool {
+	context := visitContext{
+		visit:       key,
+		isUnordered: _this.isUnorderedAtCurrentPath(isUnorderedField),
+	}
+	if len(_this.options.unorderedPaths) > 0 {
+		context.path = _this.path.String()
+	}
+	if result, ok := _this.visited[context]; ok && !_this.mustCompareAgain(result) {
+		// Any differences have already been reported.
+		return result.isEquivalent
+	}
+	if depth, ok := _this.visiting[key]; ok {
+		_this.lowestCycleDepth = lowerCycleDepth(_this.lowestCycleDepth, depth)
+		return true
+	}
+
+	if _this.visiting == nil {
+		// Only made when needed, since many comparisons never get here.
+		_this.visiting = make(map[visit]int)
+		_this.visited = make(map[visitContext]visitResult)
+	}
+	_this.visitDepth++
+	depth := _this.visitDepth
+	_this.visiting[key] = depth
+	outerCycleDepth := _this.lowestCycleDepth
+	_this.lowestCycleDepth = 0
+	defer func() {
+		delete(_this.visiting, key)
+		_this.visitDepth--
+		innerCycleDepth := _this.lowestCycleDepth
+		if innerCycleDepth >= depth {
+			// Only this pair relied on it, and it's finished now.
+			innerCycleDepth = 0
+		}
+		_this.lowestCycleDepth = lowerCycleDepth(outerCycleDepth, innerCycleDepth)
+	}()
+
+	isEquivalent := _this.areValuesEquivalent(a, b, isUnorderedField)
+	if !isEquivalent || _this.lowestCycleDepth == 0 || _this.lowestCycleDepth >= depth {
+		_this.visited[context] = visitResult{
+			isEquivalent: isEquivalent,
+			isIsolated:   _this.isIsolated,
+		}
+	}
+	return isEquivalent
+}
+
+// Get the lower of two cycle depths, where 0 means no cycle.
+func lowerCycleDepth(a, b int) int {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func isReferenceKind(kind reflect.Kind) bool {
+	return kind == reflect.Map || kind == reflect.Slice
+}
+
+// Where an object on one side was first paired with an object on the other.
+type aliasPairing struct {
+	other identity
+	path  path
+}
+
+type aliasTracker struct {
+	aToB map[identity]aliasPairing
+	bToA map[identity]aliasPairing
+}
+
+// Get the identity of v for alias tracking, if v was reached by reference.
+//
+// Zero-sized objects and empty slices are skipped, since unrelated ones may
+// share the same address.
+func getAliasIdentity(v reflect.Value, wasPointer bool) (id identity, ok bool) {
+	if !v.IsValid() || (!wasPointer && !isReferenceKind(v.Kind())) {
+		return id, false
+	}
+	if v.Type().Size() == 0 || (v.Kind() == reflect.Slice && (v.Len() == 0 || v.Type().Elem().Size() == 0)) {
+		return id, false
+	}
+	return getIdentity(v)
+}
+
+// Check that a and b are paired with each other and nothing else, recording the
+// pairing if this is the first time either of them has been seen. Returns false
+// (recording a difference) if one of them was already paired with a different
+// object.
+//
+// Only pairs where both sides were reached by reference are checked, so that a
+// pointer can still be equivalent to a plain value.
+func (_this *comparator) checkAliasing(a, b reflect.Value, aWasPointer, bWasPointer bool) bool {
+	if !_this.options.preserveAliasing {
+		return true
+	}
+	aID, ok := getAliasIdentity(a, aWasPointer)
+	if !ok {
+		return true
+	}
+	bID, ok := getAliasIdentity(b, bWasPointer)
+	if !ok {
+		return true
+	}
+
+	tracker := &_this.aliases
+	if tracker.aToB == nil {
+		tracker.aToB = make(map[identity]aliasPairing)
+		tracker.bToA = make(map[identity]aliasPairing)
+	}
+	if pairing, ok := tracker.aToB[aID]; ok && pairing.other != bID {
+		return _this.failWithNote(ReasonAliasingDiffers,
+			fmt.Sprintf("A is also at %v, but B is not", describePath(pairing.path)), a, b)
+	}
+	if pairing, ok := tracker.bToA[bID]; ok && pairing.other != aID {
+		return _this.failWithNote(ReasonAliasingDiffers,
+			fmt.Sprintf("B is also at %v, but A is not", describePath(pairing.path)), a, b)
+	}
+	if _, ok := tracker.aToB[aID]; !ok {
+		currentPath := append(path(nil), _this.path...)
+		tracker.aToB[aID] = aliasPairing{other: bID, path: currentPath}
+		tracker.bToA[bID] = aliasPairing{other: aID, path: currentPath}
+	}
+	return true
+}
+
+func describePath(p path) string {
+	if len(p) == 0 {
+		return "(root)"
+	}
+	return p.String()
+}